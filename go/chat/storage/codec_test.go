@@ -0,0 +1,106 @@
+package storage
+
+import "testing"
+
+type testBlob struct {
+	A string
+	B int
+}
+
+func TestCompressingCodecRoundTripBelowThreshold(t *testing.T) {
+	codec := NewCompressingCodec(1 << 20)
+	in := testBlob{A: "small blob", B: 42}
+	enc, err := codec.Encode(in)
+	if err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+	if blobAlgo(enc[0]) != blobAlgoRaw {
+		t.Fatalf("expected a payload under threshold to stay raw, got algo %d", enc[0])
+	}
+	var out testBlob
+	if err := codec.Decode(enc, &out); err != nil {
+		t.Fatalf("Decode: %s", err)
+	}
+	if out != in {
+		t.Fatalf("round trip mismatch: got %+v, want %+v", out, in)
+	}
+}
+
+func TestCompressingCodecRoundTripAboveThreshold(t *testing.T) {
+	codec := NewCompressingCodec(0)
+	in := testBlob{A: "this one compresses since the threshold is zero", B: 7}
+	enc, err := codec.Encode(in)
+	if err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+	if blobAlgo(enc[0]) != blobAlgoGzip {
+		t.Fatalf("expected a payload at/above threshold to compress, got algo %d", enc[0])
+	}
+	var out testBlob
+	if err := codec.Decode(enc, &out); err != nil {
+		t.Fatalf("Decode: %s", err)
+	}
+	if out != in {
+		t.Fatalf("round trip mismatch: got %+v, want %+v", out, in)
+	}
+}
+
+// TestCompressingCodecThresholdBoundary checks the off-by-one at the
+// compression threshold itself: a payload exactly at threshold compresses,
+// one byte under stays raw.
+func TestCompressingCodecThresholdBoundary(t *testing.T) {
+	in := testBlob{A: "x", B: 1}
+	raw, err := encode(in)
+	if err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+
+	atThreshold := NewCompressingCodec(len(raw))
+	enc, err := atThreshold.Encode(in)
+	if err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+	if blobAlgo(enc[0]) != blobAlgoGzip {
+		t.Fatalf("expected a payload exactly at threshold to compress, got algo %d", enc[0])
+	}
+
+	underThreshold := NewCompressingCodec(len(raw) + 1)
+	enc, err = underThreshold.Encode(in)
+	if err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+	if blobAlgo(enc[0]) != blobAlgoRaw {
+		t.Fatalf("expected a payload one byte under threshold to stay raw, got algo %d", enc[0])
+	}
+	var out testBlob
+	if err := underThreshold.Decode(enc, &out); err != nil {
+		t.Fatalf("Decode: %s", err)
+	}
+	if out != in {
+		t.Fatalf("round trip mismatch: got %+v, want %+v", out, in)
+	}
+}
+
+func TestRawCodecRoundTrip(t *testing.T) {
+	codec := rawCodec{}
+	in := testBlob{A: "plain", B: 7}
+	enc, err := codec.Encode(in)
+	if err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+	var out testBlob
+	if err := codec.Decode(enc, &out); err != nil {
+		t.Fatalf("Decode: %s", err)
+	}
+	if out != in {
+		t.Fatalf("round trip mismatch: got %+v, want %+v", out, in)
+	}
+}
+
+func TestCompressingCodecDecodeRejectsUnknownAlgo(t *testing.T) {
+	codec := NewCompressingCodec(0)
+	var out testBlob
+	if err := codec.Decode([]byte{0xff, 1, 2, 3}, &out); err == nil {
+		t.Fatal("expected an error decoding an unrecognized algorithm byte")
+	}
+}
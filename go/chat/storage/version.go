@@ -13,6 +13,7 @@ type ServerVersions struct {
 	libkb.Contextified
 	utils.DebugLabeler
 
+	codec  BlobCodec
 	cached *chat1.ServerCacheVers
 }
 
@@ -20,6 +21,7 @@ func NewServerVersions(g *libkb.GlobalContext) *ServerVersions {
 	return &ServerVersions{
 		Contextified: libkb.NewContextified(g),
 		DebugLabeler: utils.NewDebugLabeler(g, "ServerVersions", false),
+		codec:        NewChatCacheCodec(g),
 	}
 }
 
@@ -47,7 +49,7 @@ func (s *ServerVersions) fetchLocked(ctx context.Context) (chat1.ServerCacheVers
 		return chat1.ServerCacheVers{}, nil
 	}
 	var srvVers chat1.ServerCacheVers
-	if err = decode(raw, &srvVers); err != nil {
+	if err = s.codec.Decode(raw, &srvVers); err != nil {
 		return chat1.ServerCacheVers{},
 			NewInternalError(ctx, s.DebugLabeler, "decode error: %s", err.Error())
 	}
@@ -99,7 +101,7 @@ func (s *ServerVersions) Sync(ctx context.Context, vers chat1.ServerCacheVers) E
 	s.cached = &vers
 
 	// Write out to LevelDB
-	dat, err := encode(vers)
+	dat, err := s.codec.Encode(vers)
 	if err != nil {
 		return NewInternalError(ctx, s.DebugLabeler, "encode error: %s", err.Error())
 	}
@@ -4,7 +4,14 @@
 package client
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -21,7 +28,14 @@ type CmdSimpleFSMove struct {
 	dest        keybase1.Path
 	interactive bool
 	force       bool
+	jobs        int
+	progress    bool
+	resume      bool
 	opCanceler  *OpCanceler
+
+	// promptMu serializes the -i/-f overwrite prompt across move workers so
+	// concurrent jobs don't stomp each other on the TTY.
+	promptMu sync.Mutex
 }
 
 var _ Canceler = (*CmdSimpleFSMove)(nil)
@@ -47,10 +61,230 @@ func NewCmdSimpleFSMove(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.
 				Name:  "f, force",
 				Usage: "force overwrite",
 			},
+			cli.IntFlag{
+				Name:  "jobs",
+				Value: 1,
+				Usage: "number of moves to run concurrently",
+			},
+			cli.BoolFlag{
+				Name:  "progress",
+				Usage: "show aggregated progress while moving",
+			},
+			cli.BoolFlag{
+				Name:  "resume",
+				Usage: "resume a previously interrupted mv, skipping completed entries",
+			},
 		},
 	}
 }
 
+// moveJournalEntry tracks the state of a single source/dest pair so an
+// interrupted `mv --resume` run can skip what already finished and restart
+// whatever was still in flight.
+type moveJournalEntry struct {
+	Src   keybase1.Path `json:"src"`
+	Dest  keybase1.Path `json:"dest"`
+	OpID  keybase1.OpID `json:"opid"`
+	State string        `json:"state"` // "pending", "moving", "done"
+}
+
+func (c *CmdSimpleFSMove) journalPath() (string, error) {
+	configDir := c.G().Env.GetConfigDir()
+	if configDir == "" {
+		return "", errors.New("no config dir available for mv journal")
+	}
+	return filepath.Join(configDir, "simplefs-mv.journal"), nil
+}
+
+func (c *CmdSimpleFSMove) loadJournal() (map[string]*moveJournalEntry, error) {
+	if !c.resume {
+		return make(map[string]*moveJournalEntry), nil
+	}
+	path, err := c.journalPath()
+	if err != nil {
+		return make(map[string]*moveJournalEntry), nil
+	}
+	return loadJournalFile(path)
+}
+
+func (c *CmdSimpleFSMove) saveJournal(entries map[string]*moveJournalEntry) error {
+	path, err := c.journalPath()
+	if err != nil {
+		return nil
+	}
+	return saveJournalFile(path, entries)
+}
+
+// loadJournalFile reads and decodes the journal at path, keyed by each
+// entry's source path. A missing file is not an error: it just means there
+// is nothing to resume yet.
+func loadJournalFile(path string) (map[string]*moveJournalEntry, error) {
+	entries := make(map[string]*moveJournalEntry)
+	dat, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return entries, err
+	}
+	var list []*moveJournalEntry
+	if err := json.Unmarshal(dat, &list); err != nil {
+		return entries, err
+	}
+	for _, e := range list {
+		entries[pathToString(e.Src)] = e
+	}
+	return entries, nil
+}
+
+// saveJournalFile encodes entries and writes them to path, overwriting
+// whatever was there before.
+func saveJournalFile(path string, entries map[string]*moveJournalEntry) error {
+	list := make([]*moveJournalEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	dat, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, dat, libkb.PermFile)
+}
+
+// journalShouldSkip reports whether journal already has key recorded as
+// "done", meaning a prior `mv --resume` run finished this entry and Run
+// shouldn't redo it. Entries in any other state ("pending" or "moving")
+// are retried, since the interrupted run may not have finished them.
+func journalShouldSkip(journal map[string]*moveJournalEntry, key string) bool {
+	prior, ok := journal[key]
+	return ok && prior.State == "done"
+}
+
+func (c *CmdSimpleFSMove) removeJournal() {
+	path, err := c.journalPath()
+	if err != nil {
+		return
+	}
+	os.Remove(path)
+}
+
+// moveProgress is the shared, lock-protected accumulator the progress
+// goroutine renders from while workers update it as each SimpleFSCheck
+// comes back.
+type moveProgress struct {
+	sync.Mutex
+	writtenByOp map[keybase1.OpID]int64
+	totalByOp   map[keybase1.OpID]int64
+}
+
+func newMoveProgress() *moveProgress {
+	return &moveProgress{
+		writtenByOp: make(map[keybase1.OpID]int64),
+		totalByOp:   make(map[keybase1.OpID]int64),
+	}
+}
+
+func (p *moveProgress) update(opid keybase1.OpID, written, total int64) {
+	p.Lock()
+	defer p.Unlock()
+	p.writtenByOp[opid] = written
+	p.totalByOp[opid] = total
+}
+
+func (p *moveProgress) clear(opid keybase1.OpID) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.writtenByOp, opid)
+	delete(p.totalByOp, opid)
+}
+
+func (p *moveProgress) render() string {
+	p.Lock()
+	defer p.Unlock()
+	var written, total int64
+	for opid, w := range p.writtenByOp {
+		written += w
+		total += p.totalByOp[opid]
+	}
+	if total == 0 {
+		return fmt.Sprintf("\rmv: %d active, %d bytes moved", len(p.writtenByOp), written)
+	}
+	return fmt.Sprintf("\rmv: %d active, %d/%d bytes (%.1f%%)", len(p.writtenByOp), written, total,
+		100*float64(written)/float64(total))
+}
+
+// pollProgress periodically polls SimpleFSCheck on opid and feeds the
+// result into p until ctx is cancelled (the move for this opid finished).
+func (c *CmdSimpleFSMove) pollProgress(ctx context.Context, cli keybase1.SimpleFSInterface, opid keybase1.OpID, p *moveProgress) {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			p.clear(opid)
+			return
+		case <-ticker.C:
+			prog, err := cli.SimpleFSCheck(ctx, opid)
+			if err != nil {
+				continue
+			}
+			p.update(opid, int64(prog.BytesWritten), int64(prog.BytesTotal))
+			fmt.Fprint(os.Stderr, p.render())
+		}
+	}
+}
+
+// doOverwritePromptSerialized wraps doOverwritePrompt so that concurrent
+// --jobs workers don't interleave prompts on the TTY.
+func (c *CmdSimpleFSMove) doOverwritePromptSerialized(dest string) error {
+	c.promptMu.Lock()
+	defer c.promptMu.Unlock()
+	return doOverwritePrompt(c.G(), dest)
+}
+
+// moveOne runs a single src->dest move to completion, recording progress
+// (if enabled) and journal state along the way. Journal updates are sent
+// as values on journalCh rather than through a shared *moveJournalEntry,
+// so the journal-writer goroutine never reads a struct a worker is
+// concurrently mutating.
+func (c *CmdSimpleFSMove) moveOne(ctx context.Context, cli keybase1.SimpleFSInterface, src, dest keybase1.Path,
+	progress *moveProgress, journalCh chan<- moveJournalEntry) error {
+	c.G().Log.Debug("SimpleFSMove %s -> %s", pathToString(src), pathToString(dest))
+
+	if c.opCanceler.IsCancelled() {
+		return nil
+	}
+
+	opid, err := cli.SimpleFSMakeOpid(ctx)
+	if err != nil {
+		return err
+	}
+	c.opCanceler.AddOp(opid)
+	defer cli.SimpleFSClose(ctx, opid)
+
+	journalCh <- moveJournalEntry{Src: src, Dest: dest, OpID: opid, State: "moving"}
+
+	if c.progress {
+		pollCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go c.pollProgress(pollCtx, cli, opid, progress)
+	}
+
+	if err = cli.SimpleFSMove(ctx, keybase1.SimpleFSMoveArg{
+		OpID: opid,
+		Src:  src,
+		Dest: dest,
+	}); err != nil {
+		return err
+	}
+	if err = cli.SimpleFSWait(ctx, opid); err != nil {
+		return err
+	}
+
+	journalCh <- moveJournalEntry{Src: src, Dest: dest, OpID: opid, State: "done"}
+	return nil
+}
+
 // Run runs the command in client/server mode.
 func (c *CmdSimpleFSMove) Run() error {
 	cli, err := GetSimpleFSClient(c.G())
@@ -70,51 +304,96 @@ func (c *CmdSimpleFSMove) Run() error {
 		return err
 	}
 
-	for _, src := range destPaths {
-		c.G().Log.Debug("SimpleFSMove %s -> %s, %v", pathToString(src), destPathString, isDestDir)
-
-		dest, err := makeDestPath(c.G(), ctx, cli, src, c.dest, isDestDir, destPathString)
-
-		if err == ErrTargetFileExists {
-			if c.interactive == true {
-				err = doOverwritePrompt(c.G(), pathToString(dest))
-			} else if c.force == true {
-				err = nil
+	journal, jerr := c.loadJournal()
+	if jerr != nil {
+		c.G().Log.Warning("could not load mv journal, starting fresh: %s", jerr)
+		journal = make(map[string]*moveJournalEntry)
+	}
+	journalCh := make(chan moveJournalEntry, len(destPaths))
+	var journalWG sync.WaitGroup
+	journalWG.Add(1)
+	go func() {
+		defer journalWG.Done()
+		for entry := range journalCh {
+			entry := entry
+			journal[pathToString(entry.Src)] = &entry
+			if werr := c.saveJournal(journal); werr != nil {
+				c.G().Log.Warning("could not persist mv journal: %s", werr)
 			}
 		}
+	}()
 
-		if err != nil {
-			return err
-		}
-		c.G().Log.Debug("SimpleFSMove %s -> %s", pathToString(src), pathToString(dest))
+	progress := newMoveProgress()
 
-		// Don't spawn new jobs if we've been cancelled.
-		// TODO: This is still a race condition, if we get cancelled immediately after.
-		if c.opCanceler.IsCancelled() {
-			break
+	jobs := c.jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+
+	for _, src := range destPaths {
+		if journalShouldSkip(journal, pathToString(src)) {
+			c.G().Log.Debug("SimpleFSMove skipping already-completed %s", pathToString(src))
+			continue
 		}
 
-		opid, err := cli.SimpleFSMakeOpid(ctx)
-		if err != nil {
-			return err
+		c.G().Log.Debug("SimpleFSMove %s -> %s, %v", pathToString(src), destPathString, isDestDir)
+
+		dest, derr := makeDestPath(c.G(), ctx, cli, src, c.dest, isDestDir, destPathString)
+		if derr == ErrTargetFileExists {
+			if c.interactive {
+				derr = c.doOverwritePromptSerialized(pathToString(dest))
+			} else if c.force {
+				derr = nil
+			}
 		}
-		c.opCanceler.AddOp(opid)
-		defer cli.SimpleFSClose(ctx, opid)
-
-		err = cli.SimpleFSMove(ctx, keybase1.SimpleFSMoveArg{
-			OpID: opid,
-			Src:  src,
-			Dest: dest,
-		})
-		if err != nil {
+		if derr != nil {
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = derr
+			}
+			mu.Unlock()
 			break
 		}
-		err = cli.SimpleFSWait(ctx, opid)
-		if err != nil {
+
+		if c.opCanceler.IsCancelled() {
 			break
 		}
+
+		journalCh <- moveJournalEntry{Src: src, Dest: dest, State: "pending"}
+
+		src, dest := src, dest
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if merr := c.moveOne(ctx, cli, src, dest, progress, journalCh); merr != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = merr
+				}
+				mu.Unlock()
+			}
+		}()
 	}
-	return err
+
+	wg.Wait()
+	close(journalCh)
+	journalWG.Wait()
+
+	if c.progress {
+		fmt.Fprintln(os.Stderr)
+	}
+
+	if firstErr == nil {
+		c.removeJournal()
+	}
+
+	return firstErr
 }
 
 // ParseArgv does nothing for this command.
@@ -122,6 +401,9 @@ func (c *CmdSimpleFSMove) ParseArgv(ctx *cli.Context) error {
 	var err error
 	c.interactive = ctx.Bool("interactive")
 	c.force = ctx.Bool("force")
+	c.jobs = ctx.Int("jobs")
+	c.progress = ctx.Bool("progress")
+	c.resume = ctx.Bool("resume")
 
 	if c.force && c.interactive {
 		return errors.New("force and interactive are incompatible")
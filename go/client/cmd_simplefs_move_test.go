@@ -0,0 +1,108 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package client
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	keybase1 "github.com/keybase/client/go/protocol/keybase1"
+)
+
+func TestMoveJournalEntryJSONRoundTrip(t *testing.T) {
+	in := moveJournalEntry{OpID: keybase1.OpID{9}, State: "pending"}
+
+	dat, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal: %s", err)
+	}
+	var out moveJournalEntry
+	if err := json.Unmarshal(dat, &out); err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	if out.OpID != in.OpID || out.State != in.State {
+		t.Fatalf("round trip mismatch: got %+v, want %+v", out, in)
+	}
+}
+
+func TestLoadSaveJournalFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "simplefs-mv.journal")
+
+	in := &moveJournalEntry{OpID: keybase1.OpID{1}, State: "moving"}
+	key := pathToString(in.Src)
+	entries := map[string]*moveJournalEntry{key: in}
+
+	if err := saveJournalFile(path, entries); err != nil {
+		t.Fatalf("saveJournalFile: %s", err)
+	}
+
+	got, err := loadJournalFile(path)
+	if err != nil {
+		t.Fatalf("loadJournalFile: %s", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected 1 entry after reload, got %d: %+v", len(got), got)
+	}
+	gotEntry, ok := got[key]
+	if !ok {
+		t.Fatalf("expected an entry keyed by %q, got keys %v", key, got)
+	}
+	if gotEntry.State != in.State || gotEntry.OpID != in.OpID {
+		t.Fatalf("round trip mismatch: got %+v, want %+v", gotEntry, in)
+	}
+}
+
+func TestLoadJournalFileMissingFileReturnsEmpty(t *testing.T) {
+	got, err := loadJournalFile(filepath.Join(t.TempDir(), "does-not-exist"))
+	if err != nil {
+		t.Fatalf("unexpected error for a missing journal file: %s", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("expected an empty journal, got %+v", got)
+	}
+}
+
+func TestJournalShouldSkip(t *testing.T) {
+	journal := map[string]*moveJournalEntry{
+		"done-entry":    {State: "done"},
+		"pending-entry": {State: "pending"},
+		"moving-entry":  {State: "moving"},
+	}
+	cases := []struct {
+		key  string
+		want bool
+	}{
+		{"done-entry", true},
+		{"pending-entry", false},
+		{"moving-entry", false},
+		{"unknown-entry", false},
+	}
+	for _, c := range cases {
+		if got := journalShouldSkip(journal, c.key); got != c.want {
+			t.Errorf("journalShouldSkip(%q) = %v, want %v", c.key, got, c.want)
+		}
+	}
+}
+
+func TestMoveProgressRenderReflectsAggregateAcrossOps(t *testing.T) {
+	p := newMoveProgress()
+	p.update(keybase1.OpID{1}, 10, 100)
+	p.update(keybase1.OpID{2}, 20, 200)
+
+	got := p.render()
+	if !strings.Contains(got, "2 active") {
+		t.Fatalf("expected render to report 2 active ops, got %q", got)
+	}
+	if !strings.Contains(got, "30/300") {
+		t.Fatalf("expected render to report aggregated bytes 30/300, got %q", got)
+	}
+
+	p.clear(keybase1.OpID{1})
+	got = p.render()
+	if !strings.Contains(got, "1 active") {
+		t.Fatalf("expected render to report 1 active op after clear, got %q", got)
+	}
+}
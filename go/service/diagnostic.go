@@ -0,0 +1,171 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// diagnostic is an optional loopback-only HTTP endpoint (off by default,
+// see --diag-addr) that surfaces health, Go's stdlib profiler, and
+// subsystem status to anything that can reach localhost but not the RPC
+// socket -- a watchdog script, a packager's post-install check, and the
+// like.
+type diagnostic struct {
+	libkb.Contextified
+	service   *Service
+	startTime time.Time
+	listener  net.Listener
+}
+
+func newDiagnostic(g *libkb.GlobalContext, service *Service) *diagnostic {
+	return &diagnostic{
+		Contextified: libkb.NewContextified(g),
+		service:      service,
+		startTime:    time.Now(),
+	}
+}
+
+// Start binds addr and begins serving in the background. It registers a
+// shutdown hook to close the listener, so callers don't need to track it.
+// addr must name a loopback address; Start refuses to bind anything else
+// rather than trusting the caller (see loopbackOnly).
+func (d *diagnostic) Start(addr string) error {
+	if err := loopbackOnly(addr); err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	d.listener = l
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", d.handleHealthz)
+	mux.HandleFunc("/readyz", d.handleReadyz)
+	mux.HandleFunc("/metrics", d.handleMetrics)
+	mux.HandleFunc("/debug/status", d.handleDebugStatus)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			d.G().Log.Warning("diagnostic server exited: %s", err)
+		}
+	}()
+	d.G().PushShutdownHook(func() error {
+		return l.Close()
+	})
+	d.G().Log.Info("diagnostic endpoint listening on %s", l.Addr())
+	return nil
+}
+
+// loopbackOnly reports an error unless addr's host resolves to nothing but
+// loopback addresses. /debug/pprof/profile and /debug/pprof/trace can be
+// used to pull a CPU/memory profile off the box, and /debug/status leaks
+// connection and login state, so an operator fat-fingering --diag-addr into
+// something like "0.0.0.0:PORT" or ":PORT" (which binds every interface,
+// not just loopback) must not silently expose them to the network.
+func loopbackOnly(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid diagnostic address %q: %s", addr, err)
+	}
+	if host == "" {
+		return fmt.Errorf("diagnostic address %q binds all interfaces; give an explicit loopback host (e.g. 127.0.0.1:PORT)", addr)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if !ip.IsLoopback() {
+			return fmt.Errorf("diagnostic address %q is not a loopback address", addr)
+		}
+		return nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return fmt.Errorf("could not resolve diagnostic host %q: %s", host, err)
+	}
+	for _, ip := range ips {
+		if !ip.IsLoopback() {
+			return fmt.Errorf("diagnostic host %q resolves to non-loopback address %s", host, ip)
+		}
+	}
+	return nil
+}
+
+func (d *diagnostic) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "ok")
+}
+
+// readiness reflects the state of the subsystems Service.RunBackgroundOperations
+// manages, so a caller can tell "process alive but gregor stuck" apart from
+// genuinely healthy.
+func (d *diagnostic) readiness() map[string]bool {
+	return map[string]bool{
+		"gregor_connected":   d.service.GregorState() == GregorConnected,
+		"deliverer_running":  d.service.delivererStarted,
+		"local_db_open":      d.service.localDbOpen,
+		"local_chat_db_open": d.service.localChatDbOpen,
+		"logged_in":          d.service.triedLoginSucceeded,
+	}
+}
+
+func (d *diagnostic) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := d.readiness()
+	ok := true
+	for _, v := range checks {
+		ok = ok && v
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(checks)
+}
+
+func (d *diagnostic) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for name, up := range d.readiness() {
+		val := 0
+		if up {
+			val = 1
+		}
+		fmt.Fprintf(w, "# TYPE keybase_service_%s gauge\nkeybase_service_%s %d\n", name, name, val)
+	}
+}
+
+// debugStatus is the JSON shape served at /debug/status.
+type debugStatus struct {
+	Uptime         string               `json:"uptime"`
+	ForkType       keybase1.ForkType    `json:"fork_type"`
+	ConnectionIDs  []libkb.ConnectionID `json:"connection_ids"`
+	LastHourlyTick time.Time            `json:"last_hourly_tick"`
+	GregorState    GregorConnState      `json:"gregor_state"`
+}
+
+func (d *diagnostic) handleDebugStatus(w http.ResponseWriter, r *http.Request) {
+	status := debugStatus{
+		Uptime:         time.Since(d.startTime).String(),
+		ForkType:       d.service.ForkType,
+		ConnectionIDs:  d.G().NotifyRouter.GetConnectionIDs(),
+		LastHourlyTick: d.service.lastHourlyCheck,
+		GregorState:    d.service.GregorState(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,29 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package service
+
+import "testing"
+
+func TestLoopbackOnly(t *testing.T) {
+	cases := []struct {
+		addr    string
+		wantErr bool
+	}{
+		{"127.0.0.1:1234", false},
+		{"[::1]:1234", false},
+		{"0.0.0.0:1234", true},
+		{":1234", true},
+		{"10.0.0.5:1234", true},
+		{"not-an-address", true},
+	}
+	for _, c := range cases {
+		err := loopbackOnly(c.addr)
+		if c.wantErr && err == nil {
+			t.Errorf("loopbackOnly(%q): expected an error, got nil", c.addr)
+		}
+		if !c.wantErr && err != nil {
+			t.Errorf("loopbackOnly(%q): expected no error, got %s", c.addr, err)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package service
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// GregorConnState is the gregorSupervisor's view of the connection to
+// gregord. It's exposed through Service.GregorState() for the diagnostic
+// endpoint and pushed to UIs via NotifyRouter, so a flaky network shows up
+// as "reconnecting..." instead of a stale "connected".
+type GregorConnState string
+
+const (
+	GregorDisconnected GregorConnState = "disconnected"
+	GregorConnecting   GregorConnState = "connecting"
+	GregorConnected    GregorConnState = "connected"
+	GregorBackoff      GregorConnState = "backoff"
+)
+
+const (
+	gregorBackoffMin    = 1 * time.Second
+	gregorBackoffMax    = 60 * time.Second
+	gregorHealthPoll    = 5 * time.Second
+	gregorLoggedOutPoll = 5 * time.Second
+)
+
+// gregorSupervisor replaces the one-shot tryGregordConnect/OnLogin calls
+// with a retry loop: it calls Service.gregordConnect with jittered
+// exponential backoff until it sticks, resets the backoff on any success,
+// and pauses entirely while logged out instead of burning retries against
+// a server that'll just reject them. ForceRetry lets OnLogin and the
+// reachability subsystem wake it up immediately instead of waiting out
+// whatever backoff or poll interval it's currently sleeping through.
+type gregorSupervisor struct {
+	libkb.Contextified
+	service *Service
+
+	mu    sync.Mutex
+	state GregorConnState
+
+	retryNow chan struct{}
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+func newGregorSupervisor(g *libkb.GlobalContext, service *Service) *gregorSupervisor {
+	return &gregorSupervisor{
+		Contextified: libkb.NewContextified(g),
+		service:      service,
+		state:        GregorDisconnected,
+		retryNow:     make(chan struct{}, 1),
+		stopCh:       make(chan struct{}),
+	}
+}
+
+// Run is the supervisor loop. startupGregor starts it in its own goroutine
+// and it runs until Stop is called.
+func (s *gregorSupervisor) Run() {
+	backoff := gregorBackoffMin
+	for {
+		// LoggedInLoad can return a network error while we're actually
+		// logged in (it confirms with the API server); swallow that case
+		// and fall through to the gregor loop rather than treating it as
+		// logged out, same as tryGregordConnect used to.
+		loggedIn, err := s.service.G().LoginState().LoggedInLoad()
+		if err != nil {
+			if _, isNetworkError := err.(libkb.APINetError); !isNetworkError {
+				s.G().Log.Warning("gregorSupervisor: unexpected error checking login state: %s", err)
+			}
+		} else if !loggedIn {
+			s.setState(GregorDisconnected)
+			if !s.sleep(gregorLoggedOutPoll) {
+				return
+			}
+			continue
+		}
+
+		if s.service.HasGregor() {
+			s.setState(GregorConnected)
+			backoff = gregorBackoffMin
+			if !s.sleep(gregorHealthPoll) {
+				return
+			}
+			continue
+		}
+
+		s.setState(GregorConnecting)
+		if err := s.service.gregordConnect(); err != nil {
+			s.G().Log.Debug("gregorSupervisor: connect failed, backing off %s: %s", backoff, err)
+			s.setState(GregorBackoff)
+			if !s.sleep(jitter(backoff)) {
+				return
+			}
+			backoff = nextBackoff(backoff)
+			continue
+		}
+	}
+}
+
+// ForceRetry wakes the supervisor immediately, short-circuiting whatever
+// backoff or poll it's currently sleeping through. It's safe to call from
+// any goroutine, including before the supervisor has started sleeping.
+func (s *gregorSupervisor) ForceRetry() {
+	select {
+	case s.retryNow <- struct{}{}:
+	default:
+	}
+}
+
+// Stop ends the supervisor loop. It's idempotent.
+func (s *gregorSupervisor) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+}
+
+// State reports the supervisor's current view of the connection.
+func (s *gregorSupervisor) State() GregorConnState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// sleep waits for d, an early wakeup via ForceRetry, or Stop, returning
+// false only in the Stop case so callers know to exit their loop.
+func (s *gregorSupervisor) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-s.retryNow:
+		return true
+	case <-s.stopCh:
+		return false
+	}
+}
+
+func (s *gregorSupervisor) setState(next GregorConnState) {
+	s.mu.Lock()
+	prev := s.state
+	s.state = next
+	s.mu.Unlock()
+	if prev == next {
+		return
+	}
+	s.G().Log.Debug("gregorSupervisor: %s -> %s", prev, next)
+	reachable := keybase1.Reachable_NO
+	if next == GregorConnected {
+		reachable = keybase1.Reachable_YES
+	}
+	s.G().NotifyRouter.HandleReachability(keybase1.Reachability{Reachable: reachable})
+}
+
+// jitter returns a duration in [d/2, d], so a fleet of clients that all lost
+// gregord at once don't all retry in lockstep.
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half+1)))
+}
+
+func nextBackoff(d time.Duration) time.Duration {
+	d *= 2
+	if d > gregorBackoffMax {
+		return gregorBackoffMax
+	}
+	return d
+}
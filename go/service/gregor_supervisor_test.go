@@ -0,0 +1,29 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package service
+
+import "testing"
+
+func TestNextBackoffDoublesAndCaps(t *testing.T) {
+	d := gregorBackoffMin
+	for d < gregorBackoffMax {
+		next := nextBackoff(d)
+		if next != d*2 && next != gregorBackoffMax {
+			t.Fatalf("nextBackoff(%s) = %s, expected either doubling or the cap", d, next)
+		}
+		d = next
+	}
+	if nextBackoff(gregorBackoffMax) != gregorBackoffMax {
+		t.Fatalf("expected nextBackoff to stay capped at %s once reached", gregorBackoffMax)
+	}
+}
+
+func TestJitterStaysWithinClosedRange(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		j := jitter(gregorBackoffMin)
+		if j < gregorBackoffMin/2 || j > gregorBackoffMin {
+			t.Fatalf("jitter(%s) = %s, want a value in [%s, %s]", gregorBackoffMin, j, gregorBackoffMin/2, gregorBackoffMin)
+		}
+	}
+}
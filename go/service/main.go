@@ -5,11 +5,17 @@ package service
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"os"
+	"os/exec"
+	"os/signal"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"golang.org/x/net/context"
@@ -27,6 +33,20 @@ import (
 	"github.com/keybase/go-framed-msgpack-rpc/rpc"
 )
 
+const (
+	// envInheritedListenerFD tells a re-exec'd service which inherited file
+	// descriptor carries the already-bound listening socket, so it can skip
+	// binding a new one out from under its parent.
+	envInheritedListenerFD = "KEYBASE_INHERITED_LISTENER_FD"
+	// envReexecReadyFD tells a re-exec'd service which inherited file
+	// descriptor to write a single readiness byte to once it's listening.
+	envReexecReadyFD = "KEYBASE_REEXEC_READY_FD"
+	// envLockReleaseFD tells a re-exec'd service which inherited file
+	// descriptor to block on for a single byte (or EOF) signaling that the
+	// parent has released the exclusive PID lock, so it's safe to grab it.
+	envLockReleaseFD = "KEYBASE_LOCK_RELEASE_FD"
+)
+
 type Service struct {
 	libkb.Contextified
 	isDaemon             bool
@@ -43,6 +63,56 @@ type Service struct {
 	badger               *badges.Badger
 	reachability         *reachability
 	backgroundIdentifier *BackgroundIdentifier
+	gregorSupervisor     *gregorSupervisor
+
+	// shutdownGrace is how long Stop will wait, after the listener has been
+	// closed, for in-flight connections to drain before shutdown proceeds
+	// without them. connWG tracks those
+	// in-flight Handle goroutines; skipDrain is set by a SIGQUIT to bypass
+	// the drain entirely.
+	shutdownGrace time.Duration
+	connWG        sync.WaitGroup
+	skipDrain     bool
+
+	// listener is the net.Listener ConfigRPCServer bound (or inherited), kept
+	// around so a SIGHUP reload can hand its underlying fd to a child.
+	listener net.Listener
+
+	// lockReleaseW is the write end of the pipe reexecWithInheritedListener
+	// handed to a reloaded child, kept around so ReleaseLock can signal that
+	// child once this process gives up the exclusive PID lock.
+	lockReleaseW *os.File
+
+	// diagAddr, if non-empty, is the loopback address the diagnostic HTTP
+	// endpoint binds to; diag is the subsystem itself once started.
+	diagAddr            string
+	diag                *diagnostic
+	lastHourlyCheck     time.Time
+	triedLoginSucceeded bool
+	localDbOpen         bool
+	localChatDbOpen     bool
+	delivererStarted    bool
+
+	// middlewares runs, in order, around every RPC method invocation on
+	// every connection. middlewaresMu guards it, since UseMiddleware can be
+	// called concurrently with RegisterProtocols wrapping a new connection's
+	// protocols (chat, gregor, and test code all call UseMiddleware after
+	// startup). peerUIDs tracks the unix socket peer credentials
+	// AuthRequiredMiddleware consults, keyed by connID.
+	middlewaresMu sync.RWMutex
+	middlewares   []Middleware
+	peerUIDsMu    sync.Mutex
+	peerUIDs      map[libkb.ConnectionID]int
+
+	// connCloseHooks run, in registration order, when a connection closes.
+	// RateLimitMiddleware uses this to evict its per-connection token bucket
+	// so it doesn't grow unbounded over the service's lifetime.
+	connCloseMu    sync.Mutex
+	connCloseHooks []func(libkb.ConnectionID)
+
+	// protocols holds the connection-scoped protocol factories Handle()
+	// builds each connection's RPC surface from. See ProtocolRegistry.
+	protocols *ProtocolRegistry
 }
 
 type Shutdowner interface {
@@ -50,7 +120,7 @@ type Shutdowner interface {
 }
 
 func NewService(g *libkb.GlobalContext, isDaemon bool) *Service {
-	return &Service{
+	d := &Service{
 		Contextified:    libkb.NewContextified(g),
 		isDaemon:        isDaemon,
 		startCh:         make(chan struct{}),
@@ -60,69 +130,198 @@ func NewService(g *libkb.GlobalContext, isDaemon bool) *Service {
 		attachmentstore: chat.NewAttachmentStore(g.Log, g.Env.GetRuntimeDir()),
 		badger:          badges.NewBadger(g),
 		reachability:    newReachability(g),
+		shutdownGrace:   30 * time.Second,
+		peerUIDs:        make(map[libkb.ConnectionID]int),
+		protocols:       newProtocolRegistry(),
+	}
+	d.registerBuiltinProtocols()
+	return d
+}
+
+// UseMiddleware appends Middlewares to the chain that runs around every RPC
+// method invocation on every connection. Middlewares added later run closer
+// to the real handler than ones added earlier. Chat, gregor, and test code
+// can call this to inject their own cross-cutting behavior.
+func (d *Service) UseMiddleware(middlewares ...Middleware) {
+	d.middlewaresMu.Lock()
+	defer d.middlewaresMu.Unlock()
+	d.middlewares = append(d.middlewares, middlewares...)
+}
+
+// installDefaultMiddlewares wires up the always-on cross-cutting RPC
+// behavior: panic recovery first (so it wraps everything else), then
+// structured logging, then rate limiting if the user has configured one,
+// then the same-uid gate on privilegedProtocols. chat, gregor, and test
+// code can layer on more via UseMiddleware.
+func (d *Service) installDefaultMiddlewares() {
+	d.UseMiddleware(RecoveryMiddleware(d.G()))
+	d.UseMiddleware(LoggingMiddleware(d.G()))
+	if limit := d.G().Env.GetRPCRateLimit(); limit > 0 {
+		d.UseMiddleware(RateLimitMiddleware(d, limit, 2*int(limit)))
+	}
+	d.UseMiddleware(AuthRequiredMiddleware(d, isPrivilegedMethod))
+}
+
+// recordPeerCredentials looks up the unix socket peer's uid for connID, if
+// the connection is a unix socket and the platform supports the lookup, so
+// AuthRequiredMiddleware can later decide whether this connection is allowed
+// to call privileged methods.
+func (d *Service) recordPeerCredentials(connID libkb.ConnectionID, c net.Conn) {
+	uid, err := peerUID(c)
+	if err != nil {
+		d.G().Log.Debug("could not determine peer uid for connection %d: %s", connID, err)
+		return
+	}
+	d.peerUIDsMu.Lock()
+	defer d.peerUIDsMu.Unlock()
+	d.peerUIDs[connID] = uid
+}
+
+func (d *Service) forgetPeerCredentials(connID libkb.ConnectionID) {
+	d.peerUIDsMu.Lock()
+	defer d.peerUIDsMu.Unlock()
+	delete(d.peerUIDs, connID)
+}
+
+func (d *Service) lookupPeerUID(connID libkb.ConnectionID) (int, bool) {
+	d.peerUIDsMu.Lock()
+	defer d.peerUIDsMu.Unlock()
+	uid, ok := d.peerUIDs[connID]
+	return uid, ok
+}
+
+// OnConnClose registers fn to run whenever a connection closes, passing the
+// connID being torn down. Middlewares that key state off ConnectionID (e.g.
+// RateLimitMiddleware's per-connection token buckets) use this to avoid
+// leaking that state for the service's entire lifetime.
+func (d *Service) OnConnClose(fn func(libkb.ConnectionID)) {
+	d.connCloseMu.Lock()
+	defer d.connCloseMu.Unlock()
+	d.connCloseHooks = append(d.connCloseHooks, fn)
+}
+
+func (d *Service) runConnCloseHooks(connID libkb.ConnectionID) {
+	d.connCloseMu.Lock()
+	hooks := make([]func(libkb.ConnectionID), len(d.connCloseHooks))
+	copy(hooks, d.connCloseHooks)
+	d.connCloseMu.Unlock()
+	for _, fn := range hooks {
+		fn(connID)
 	}
 }
 
+// wrapProtocols copies each rpc.Protocol's method handlers so they run
+// through d.middlewares before reaching the real handler. Protocols
+// themselves are left untouched if no middleware is installed.
+func (d *Service) wrapProtocols(protocols []rpc.Protocol, connID libkb.ConnectionID) []rpc.Protocol {
+	d.middlewaresMu.RLock()
+	middlewares := d.middlewares
+	d.middlewaresMu.RUnlock()
+	if len(middlewares) == 0 {
+		return protocols
+	}
+	wrapped := make([]rpc.Protocol, len(protocols))
+	for i, proto := range protocols {
+		methods := make(map[string]rpc.ServeHandlerDescription, len(proto.Methods))
+		for name, desc := range proto.Methods {
+			desc := desc
+			handler := desc.Handler
+			call := MiddlewareCall{ConnID: connID, Method: proto.Name + "." + name}
+			desc.Handler = func(ctx context.Context, arg interface{}) (interface{}, error) {
+				return d.runMiddlewareChain(ctx, call, func(ctx context.Context) (interface{}, error) {
+					return handler(ctx, arg)
+				})
+			}
+			methods[name] = desc
+		}
+		proto.Methods = methods
+		wrapped[i] = proto
+	}
+	return wrapped
+}
+
+// runMiddlewareChain composes d.middlewares around final, in order, with
+// the last middleware in the slice wrapping final most tightly.
+func (d *Service) runMiddlewareChain(ctx context.Context, call MiddlewareCall,
+	final func(context.Context) (interface{}, error)) (interface{}, error) {
+	d.middlewaresMu.RLock()
+	middlewares := d.middlewares
+	d.middlewaresMu.RUnlock()
+	next := final
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		mw := middlewares[i]
+		curNext := next
+		next = func(ctx context.Context) (interface{}, error) {
+			return mw(ctx, call, curNext)
+		}
+	}
+	return next(ctx)
+}
+
 func (d *Service) GetStartChannel() <-chan struct{} {
 	return d.startCh
 }
 
+// RegisterProtocols builds this connection's RPC surface from d.protocols,
+// skipping anything named in the KEYBASE_DISABLED_PROTOCOLS deny-list, and
+// registers the result (wrapped by d.middlewares) on srv.
 func (d *Service) RegisterProtocols(srv *rpc.Server, xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (shutdowners []Shutdowner, err error) {
-	protocols := []rpc.Protocol{
-		keybase1.AccountProtocol(NewAccountHandler(xp, g)),
-		keybase1.BTCProtocol(NewCryptocurrencyHandler(xp, g)),
-		keybase1.CryptocurrencyProtocol(NewCryptocurrencyHandler(xp, g)),
-		keybase1.ConfigProtocol(NewConfigHandler(xp, connID, g, d)),
-		keybase1.CryptoProtocol(NewCryptoHandler(g)),
-		keybase1.CtlProtocol(NewCtlHandler(xp, d, g)),
-		keybase1.DebuggingProtocol(NewDebuggingHandler(xp)),
-		keybase1.DelegateUiCtlProtocol(NewDelegateUICtlHandler(xp, connID, g, d.rekeyMaster)),
-		keybase1.DeviceProtocol(NewDeviceHandler(xp, g)),
-		keybase1.FavoriteProtocol(NewFavoriteHandler(xp, g)),
-		keybase1.TlfProtocol(newTlfHandler(xp, g)),
-		keybase1.IdentifyProtocol(NewIdentifyHandler(xp, g)),
-		keybase1.KbfsProtocol(NewKBFSHandler(xp, g)),
-		keybase1.KbfsMountProtocol(NewKBFSMountHandler(xp, g)),
-		keybase1.LogProtocol(NewLogHandler(xp, logReg, g)),
-		keybase1.LoginProtocol(NewLoginHandler(xp, g)),
-		keybase1.NotifyCtlProtocol(NewNotifyCtlHandler(xp, connID, g)),
-		keybase1.PGPProtocol(NewPGPHandler(xp, g)),
-		keybase1.ReachabilityProtocol(newReachabilityHandler(xp, g, d.reachability)),
-		keybase1.RevokeProtocol(NewRevokeHandler(xp, g)),
-		keybase1.ProveProtocol(NewProveHandler(xp, g)),
-		keybase1.SaltpackProtocol(NewSaltpackHandler(xp, g)),
-		keybase1.ScanProofsProtocol(NewScanProofsHandler(xp, g)),
-		keybase1.SecretKeysProtocol(NewSecretKeysHandler(xp, g)),
-		keybase1.SessionProtocol(NewSessionHandler(xp, g)),
-		keybase1.SignupProtocol(NewSignupHandler(xp, g)),
-		keybase1.SigsProtocol(NewSigsHandler(xp, g)),
-		keybase1.TestProtocol(NewTestHandler(xp, g)),
-		keybase1.TrackProtocol(NewTrackHandler(xp, g)),
-		keybase1.UserProtocol(NewUserHandler(xp, g)),
-		keybase1.ApiserverProtocol(NewAPIServerHandler(xp, g)),
-		keybase1.PaperprovisionProtocol(NewPaperProvisionHandler(xp, g)),
-		keybase1.RekeyProtocol(NewRekeyHandler2(xp, g, d.rekeyMaster)),
-		keybase1.NotifyFSRequestProtocol(newNotifyFSRequestHandler(xp, g)),
-		keybase1.GregorProtocol(newGregorRPCHandler(xp, g, d.gregor)),
-		chat1.LocalProtocol(newChatLocalHandler(xp, g, d.attachmentstore, d.gregor)),
-		keybase1.SimpleFSProtocol(NewSimpleFSHandler(xp, g)),
-		keybase1.LogsendProtocol(NewLogsendHandler(xp, g)),
-	}
-	for _, proto := range protocols {
+	protocols, shutdowners, err := d.protocols.Build(xp, connID, logReg, g, disabledProtocolSet())
+	if err != nil {
+		return shutdowners, err
+	}
+	for _, proto := range d.wrapProtocols(protocols, connID) {
 		if err = srv.Register(proto); err != nil {
-			return
+			return shutdowners, err
 		}
 	}
-	return
+	return shutdowners, nil
+}
+
+// disabledProtocolSet reads KEYBASE_DISABLED_PROTOCOLS fresh on every call
+// (rather than caching it), so it can be edited between connections without
+// restarting the service.
+func disabledProtocolSet() map[string]bool {
+	disabled := make(map[string]bool)
+	raw := os.Getenv("KEYBASE_DISABLED_PROTOCOLS")
+	if raw == "" {
+		return disabled
+	}
+	for _, name := range strings.Split(raw, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			disabled[name] = true
+		}
+	}
+	return disabled
+}
+
+// RegisterProtocolFactory adds or replaces a named protocol factory, taking
+// effect on the next connection's RegisterProtocols call. It exists so
+// out-of-tree callers (tests, a future plugin) can extend the RPC surface
+// without editing registerBuiltinProtocols.
+func (d *Service) RegisterProtocolFactory(name string, factory ProtocolFactory) {
+	d.protocols.Register(name, factory)
+}
+
+// UnregisterProtocolFactory removes a previously-registered protocol
+// factory; it has no effect on connections already served.
+func (d *Service) UnregisterProtocolFactory(name string) {
+	d.protocols.Unregister(name)
 }
 
 func (d *Service) Handle(c net.Conn) {
+	d.connWG.Add(1)
+	defer d.connWG.Done()
+
 	xp := rpc.NewTransport(c, libkb.NewRPCLogFactory(d.G()), libkb.WrapError)
 
 	server := rpc.NewServer(xp, libkb.WrapError)
 
 	cl := make(chan error, 1)
 	connID := d.G().NotifyRouter.AddConnection(xp, cl)
+	d.recordPeerCredentials(connID, c)
+	defer d.forgetPeerCredentials(connID)
+	defer d.runConnCloseHooks(connID)
 
 	var logReg *logRegister
 	if d.isDaemon {
@@ -185,6 +384,9 @@ func (d *Service) Run() (err error) {
 	uir := NewUIRouter(d.G())
 	d.G().SetUIRouter(uir)
 
+	d.installSignalHandlers()
+	d.installDefaultMiddlewares()
+
 	// register the service's logForwarder as the external handler for the log module:
 	d.G().Log.SetExternalHandler(d.logForwarder)
 
@@ -206,19 +408,30 @@ func (d *Service) Run() (err error) {
 		d.ForkType = keybase1.ForkType_LAUNCHD
 	}
 
-	if err = d.GetExclusiveLock(); err != nil {
-		return
-	}
-	if err = d.cleanupSocketFile(); err != nil {
-		return
+	// A service re-exec'd via the SIGHUP reload path inherits its listening
+	// socket straight from the parent, so it must not grab the exclusive
+	// lock (the parent still holds it until its drain completes) or delete
+	// the socket file the parent is still serving from. It grabs the lock
+	// itself, in the background, once the parent signals it has released it.
+	if os.Getenv(envInheritedListenerFD) == "" {
+		if err = d.GetExclusiveLock(); err != nil {
+			return
+		}
+		if err = d.cleanupSocketFile(); err != nil {
+			return
+		}
+	} else {
+		go d.acquireLockAfterReload()
 	}
 
 	if err = d.G().LocalDb.ForceOpen(); err != nil {
 		return err
 	}
+	d.localDbOpen = true
 	if err = d.G().LocalChatDb.ForceOpen(); err != nil {
 		return err
 	}
+	d.localChatDbOpen = true
 
 	var l net.Listener
 	if l, err = d.ConfigRPCServer(); err != nil {
@@ -237,6 +450,7 @@ func (d *Service) RunBackgroundOperations(uir *UIRouter) {
 	// We should revisit these on mobile, or at least, when mobile apps are
 	// backgrounded.
 	d.hourlyChecks()
+	d.startDiagnostic()
 	d.createChatSources()
 	d.createMessageDeliverer()
 	d.startupGregor()
@@ -248,6 +462,18 @@ func (d *Service) RunBackgroundOperations(uir *UIRouter) {
 	d.runBackgroundIdentifier()
 }
 
+// startDiagnostic binds the loopback-only diagnostic HTTP endpoint if
+// --diag-addr was given; it's a no-op (the default) otherwise.
+func (d *Service) startDiagnostic() {
+	if d.diagAddr == "" {
+		return
+	}
+	d.diag = newDiagnostic(d.G(), d)
+	if err := d.diag.Start(d.diagAddr); err != nil {
+		d.G().Log.Warning("failed to start diagnostic endpoint on %s: %s", d.diagAddr, err)
+	}
+}
+
 func (d *Service) createMessageDeliverer() {
 	ri := d.chatRemoteClient
 	si := func() libkb.SecretUI { return chat.DelivererSecretUI{} }
@@ -261,6 +487,7 @@ func (d *Service) startMessageDeliverer() {
 	uid := d.G().Env.GetUID()
 	if !uid.IsNil() {
 		d.G().MessageDeliverer.Start(context.Background(), d.G().Env.GetUID().ToBytes())
+		d.delivererStarted = true
 	}
 }
 
@@ -331,10 +558,22 @@ func (d *Service) startupGregor() {
 		// TODO -- get rid of this?
 		d.gregor.PushHandler(newRekeyLogHandler(d.G()))
 
-		// Connect to gregord
-		if gcErr := d.tryGregordConnect(); gcErr != nil {
-			g.Log.Debug("error connecting to gregord: %s", gcErr)
-		}
+		// Hand connecting to gregord off to the supervisor, which retries
+		// with backoff instead of giving up after one attempt.
+		d.gregorSupervisor = newGregorSupervisor(d.G(), d)
+		// Let the reachability subsystem wake the supervisor the moment the
+		// network comes back, instead of it waiting out the current backoff.
+		supervisor := d.gregorSupervisor
+		d.reachability.OnChange(func(state keybase1.Reachable) {
+			if state == keybase1.Reachable_YES {
+				supervisor.ForceRetry()
+			}
+		})
+		d.G().PushShutdownHook(func() error {
+			d.gregorSupervisor.Stop()
+			return nil
+		})
+		go d.gregorSupervisor.Run()
 	}
 }
 
@@ -396,37 +635,12 @@ func (d *Service) hourlyChecks() {
 			if err := d.G().LogoutIfRevoked(); err != nil {
 				d.G().Log.Debug("LogoutIfRevoked error: %s", err)
 			}
+			d.lastHourlyCheck = time.Now()
 			d.G().Log.Debug("- hourly check loop")
 		}
 	}()
 }
 
-func (d *Service) tryGregordConnect() error {
-	// If we're logged out, LoggedInLoad() will return false with no error,
-	// even if the network is down. However, if we're logged in and the network
-	// is down, it will still return false, along with the network error. We
-	// need to handle that case specifically, so that we still start the gregor
-	// connect loop.
-	loggedIn, err := d.G().LoginState().LoggedInLoad()
-	if err != nil {
-		// A network error means we *think* we're logged in, and we tried to
-		// confirm with the API server. In that case we'll swallow the error
-		// and allow control to proceeed to the gregor loop. We'll still
-		// short-circuit for any unexpected errors though.
-		_, isNetworkError := err.(libkb.APINetError)
-		if !isNetworkError {
-			d.G().Log.Warning("Unexpected non-network error in tryGregordConnect: %s", err)
-			return err
-		}
-	} else if !loggedIn {
-		// We only respect the loggedIn flag in the no-error case.
-		d.G().Log.Debug("not logged in, so not connecting to gregord")
-		return nil
-	}
-
-	return d.gregordConnect()
-}
-
 func (d *Service) runBackgroundIdentifierWithUID(u keybase1.UID) {
 	if d.G().Env.GetBGIdentifierDisabled() {
 		d.G().Log.Debug("BackgroundIdentifier disabled")
@@ -448,12 +662,16 @@ func (d *Service) runBackgroundIdentifierWithUID(u keybase1.UID) {
 
 func (d *Service) OnLogin() error {
 	d.rekeyMaster.Login()
-	if err := d.gregordConnect(); err != nil {
-		return err
+	// Wake the supervisor rather than connecting inline: a transient
+	// gregord outage at login time shouldn't fail the login, it should
+	// just mean we're briefly in backoff.
+	if d.gregorSupervisor != nil {
+		d.gregorSupervisor.ForceRetry()
 	}
 	uid := d.G().Env.GetUID()
 	if !uid.IsNil() {
 		d.G().MessageDeliverer.Start(context.Background(), d.G().Env.GetUID().ToBytes())
+		d.delivererStarted = true
 		d.runBackgroundIdentifierWithUID(uid)
 	}
 	return nil
@@ -475,6 +693,7 @@ func (d *Service) OnLogout() (err error) {
 	if d.messageDeliverer != nil {
 		d.messageDeliverer.Stop(context.Background())
 	}
+	d.delivererStarted = false
 
 	log("shutting down rekeyMaster")
 	d.rekeyMaster.Logout()
@@ -519,10 +738,18 @@ func (d *Service) gregordConnect() (err error) {
 }
 
 // ReleaseLock releases the locking pidfile by closing, unlocking and
-// deleting it.
+// deleting it. If a SIGHUP reload is in flight, this also signals the
+// reloaded child (waiting in acquireLockAfterReload) that it's now safe for
+// it to grab the lock.
 func (d *Service) ReleaseLock() error {
 	d.G().Log.Debug("Releasing lock file")
-	return d.lockPid.Close()
+	err := d.lockPid.Close()
+	if d.lockReleaseW != nil {
+		d.lockReleaseW.Write([]byte{1})
+		d.lockReleaseW.Close()
+		d.lockReleaseW = nil
+	}
+	return err
 }
 
 // GetExclusiveLockWithoutAutoUnlock grabs the exclusive lock over running
@@ -582,20 +809,240 @@ func (d *Service) lockPIDFile() (err error) {
 }
 
 func (d *Service) ConfigRPCServer() (l net.Listener, err error) {
-	if l, err = d.G().BindToSocket(); err != nil {
+	if fdStr := os.Getenv(envInheritedListenerFD); fdStr != "" {
+		l, err = d.inheritListener(fdStr)
+	} else {
+		l, err = d.G().BindToSocket()
+	}
+	if err != nil {
 		return
 	}
+	d.listener = l
 	if d.startCh != nil {
 		close(d.startCh)
 		d.startCh = nil
 	}
+	d.signalReadyIfReexeced()
 	return
 }
 
+// inheritListener wraps the fd named by the KEYBASE_INHERITED_LISTENER_FD
+// env var (set by reexecWithInheritedListener) as a net.Listener, for the
+// SIGHUP live-reload path.
+func (d *Service) inheritListener(fdStr string) (net.Listener, error) {
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		return nil, fmt.Errorf("bad %s value %q: %s", envInheritedListenerFD, fdStr, err)
+	}
+	f := os.NewFile(uintptr(fd), "keybase-inherited-socket")
+	l, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// signalReadyIfReexeced writes a single readiness byte back to the parent
+// that forked us via the SIGHUP reload path, if we were. It's a no-op for a
+// normal, non-reloaded startup.
+func (d *Service) signalReadyIfReexeced() {
+	fdStr := os.Getenv(envReexecReadyFD)
+	if fdStr == "" {
+		return
+	}
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		d.G().Log.Warning("bad %s value %q: %s", envReexecReadyFD, fdStr, err)
+		return
+	}
+	f := os.NewFile(uintptr(fd), "keybase-reexec-ready")
+	defer f.Close()
+	if _, err := f.Write([]byte{1}); err != nil {
+		d.G().Log.Warning("failed to signal readiness to parent: %s", err)
+	}
+}
+
+// acquireLockAfterReload blocks on the KEYBASE_LOCK_RELEASE_FD pipe
+// reexecWithInheritedListener set up for us until the parent that reexec'd
+// this process (via the SIGHUP live-reload path) releases its exclusive PID
+// lock, then grabs it for this process. It's a no-op for a normal,
+// non-reloaded startup. Run started this in the background rather than
+// blocking startup on it, so ConfigRPCServer/ListenLoop can begin serving on
+// the inherited socket immediately.
+func (d *Service) acquireLockAfterReload() {
+	fdStr := os.Getenv(envLockReleaseFD)
+	if fdStr == "" {
+		return
+	}
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		d.G().Log.Warning("bad %s value %q: %s", envLockReleaseFD, fdStr, err)
+		return
+	}
+	f := os.NewFile(uintptr(fd), "keybase-lock-release")
+	defer f.Close()
+	var buf [1]byte
+	if _, err := f.Read(buf[:]); err != nil && err != io.EOF {
+		d.G().Log.Warning("error waiting for parent to release its lock: %s", err)
+		return
+	}
+	if err := d.GetExclusiveLock(); err != nil {
+		d.G().Log.Warning("could not acquire exclusive lock after reload: %s", err)
+	}
+}
+
+// Stop requests a graceful shutdown: the listener stops accepting new
+// connections immediately, but Handle goroutines and shutdowners already in
+// flight get up to shutdownGrace to finish before the sockets are forced
+// closed.
 func (d *Service) Stop(exitCode keybase1.ExitCode) {
 	d.stopCh <- exitCode
 }
 
+// StopImmediately requests a hard shutdown that skips the drain entirely,
+// for operators (or SIGQUIT) that want the process gone right away.
+func (d *Service) StopImmediately(exitCode keybase1.ExitCode) {
+	d.skipDrain = true
+	d.stopCh <- exitCode
+}
+
+// installSignalHandlers maps the signals launchd/systemd and operators
+// actually send this process to the three ways it can come down or
+// reload: SIGTERM/SIGINT drain and stop gracefully, SIGQUIT skips the
+// drain, and SIGHUP re-execs in place.
+func (d *Service) installSignalHandlers() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGHUP)
+	go func() {
+		for sig := range sigCh {
+			switch sig {
+			case syscall.SIGQUIT:
+				d.G().Log.Info("received %s, forcing immediate shutdown", sig)
+				d.StopImmediately(keybase1.ExitCode_OK)
+				return
+			case syscall.SIGHUP:
+				d.G().Log.Info("received %s, attempting live reload", sig)
+				go d.reload()
+			default:
+				d.G().Log.Info("received %s, shutting down gracefully (grace=%s)", sig, d.shutdownGrace)
+				d.Stop(keybase1.ExitCode_OK)
+				return
+			}
+		}
+	}()
+}
+
+// reload implements `keybase ctl reload`'s SIGHUP path: it forks a new copy
+// of this binary that inherits our listening socket, waits for it to report
+// itself ready, and then drains this process out from under it exactly like
+// a graceful Stop would.
+func (d *Service) reload() {
+	if err := d.reexecWithInheritedListener(); err != nil {
+		d.G().Log.Warning("live reload failed, continuing to run: %s", err)
+		return
+	}
+	d.G().Log.Info("reloaded service is listening, draining this instance")
+	d.Stop(keybase1.ExitCode_OK)
+}
+
+// reexecWithInheritedListener forks/execs the current binary, handing it our
+// listening socket over an inherited fd and env var, and blocks until the
+// child signals readiness on a pipe (or a timeout elapses). It also hands
+// the child the read end of a second pipe, whose write end is kept on d
+// (see lockReleaseW) so ReleaseLock can later tell the child once it's safe
+// to grab the exclusive PID lock for itself.
+func (d *Service) reexecWithInheritedListener() error {
+	unixListener, ok := d.listener.(*net.UnixListener)
+	if !ok {
+		return fmt.Errorf("can't reload: listener is a %T, not a *net.UnixListener", d.listener)
+	}
+	listenerFile, err := unixListener.File()
+	if err != nil {
+		return err
+	}
+	defer listenerFile.Close()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+
+	lockReleaseR, lockReleaseW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer lockReleaseR.Close()
+
+	path := d.G().Env.GetServicePath()
+	if path == "" {
+		if path, err = os.Executable(); err != nil {
+			lockReleaseW.Close()
+			return err
+		}
+	}
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.ExtraFiles = []*os.File{listenerFile, readyW, lockReleaseR}
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("%s=3", envInheritedListenerFD),
+		fmt.Sprintf("%s=4", envReexecReadyFD),
+		fmt.Sprintf("%s=5", envLockReleaseFD))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		readyW.Close()
+		lockReleaseW.Close()
+		return err
+	}
+	readyW.Close()
+
+	ready := make(chan error, 1)
+	go func() {
+		var buf [1]byte
+		_, err := readyR.Read(buf[:])
+		ready <- err
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			lockReleaseW.Close()
+			return err
+		}
+		d.lockReleaseW = lockReleaseW
+		return nil
+	case <-time.After(30 * time.Second):
+		lockReleaseW.Close()
+		return errors.New("timed out waiting for reloaded service to become ready")
+	}
+}
+
+// drainConnections waits for in-flight Handle goroutines to finish, up to
+// d.shutdownGrace, logging which connections are still open if the grace
+// period elapses.
+func (d *Service) drainConnections() {
+	if d.skipDrain || d.shutdownGrace <= 0 {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		d.connWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		d.G().Log.Debug("all connections drained cleanly")
+	case <-time.After(d.shutdownGrace):
+		d.G().Log.Warning("shutdown grace period (%s) elapsed with connections still open: %v",
+			d.shutdownGrace, d.G().NotifyRouter.GetConnectionIDs())
+	}
+}
+
 func (d *Service) ListenLoopWithStopper(l net.Listener) (exitCode keybase1.ExitCode, err error) {
 	ch := make(chan error)
 	go func() {
@@ -603,6 +1050,7 @@ func (d *Service) ListenLoopWithStopper(l net.Listener) (exitCode keybase1.ExitC
 	}()
 	exitCode = <-d.stopCh
 	l.Close()
+	d.drainConnections()
 	d.G().Log.Debug("Left listen loop w/ exit code %d\n", exitCode)
 	return exitCode, <-ch
 }
@@ -633,6 +1081,10 @@ func (d *Service) ParseArgv(ctx *cli.Context) error {
 	} else if ctx.Bool("launchd-forked") {
 		d.ForkType = keybase1.ForkType_LAUNCHD
 	}
+	if grace := ctx.Duration("shutdown-grace"); grace > 0 {
+		d.shutdownGrace = grace
+	}
+	d.diagAddr = ctx.String("diag-addr")
 	return nil
 }
 
@@ -656,6 +1108,15 @@ func NewCmdService(cl *libcmdline.CommandLine, g *libkb.GlobalContext) cli.Comma
 				Name:  "watchdog-forked",
 				Usage: "Specify if this binary was started by the watchdog",
 			},
+			cli.DurationFlag{
+				Name:  "shutdown-grace",
+				Value: 30 * time.Second,
+				Usage: "how long to wait for in-flight RPCs to finish before a hard shutdown",
+			},
+			cli.StringFlag{
+				Name:  "diag-addr",
+				Usage: "bind a loopback diagnostic HTTP endpoint (/healthz, /readyz, /debug/pprof, /metrics) to this address; off by default",
+			},
 		},
 		Action: func(c *cli.Context) {
 			cl.ChooseCommand(NewService(g, true /* isDaemon */), "service", c)
@@ -705,6 +1166,16 @@ func (d *Service) HasGregor() bool {
 	return d.gregor != nil && d.gregor.IsConnected()
 }
 
+// GregorState reports the gregorSupervisor's current view of the gregord
+// connection, for the diagnostic endpoint and for tests. It's GregorDisconnected
+// if gregor hasn't started up yet (gregor disabled, Tor mode, and so on).
+func (d *Service) GregorState() GregorConnState {
+	if d.gregorSupervisor == nil {
+		return GregorDisconnected
+	}
+	return d.gregorSupervisor.State()
+}
+
 func (d *Service) SimulateGregorCrashForTesting() {
 	if d.HasGregor() {
 		d.gregor.simulateCrashForTesting()
@@ -749,5 +1220,7 @@ func (d *Service) tryLogin() {
 	ctx := &engine.Context{}
 	if err := engine.RunEngine(eng, ctx); err != nil {
 		d.G().Log.Debug("error running LoginProvisionedDevice on service startup: %s", err)
+		return
 	}
+	d.triedLoginSucceeded = true
 }
@@ -0,0 +1,74 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package service
+
+import (
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/libkb"
+)
+
+func TestConnCloseHooksRunInRegistrationOrderForTheClosingConn(t *testing.T) {
+	d := &Service{}
+
+	var got []string
+	d.OnConnClose(func(connID libkb.ConnectionID) {
+		got = append(got, "first")
+	})
+	d.OnConnClose(func(connID libkb.ConnectionID) {
+		got = append(got, "second")
+	})
+
+	d.runConnCloseHooks(libkb.ConnectionID(7))
+
+	want := []string{"first", "second"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestConnCloseHooksPassTheClosingConnID(t *testing.T) {
+	d := &Service{}
+
+	var gotConnID libkb.ConnectionID
+	d.OnConnClose(func(connID libkb.ConnectionID) {
+		gotConnID = connID
+	})
+
+	d.runConnCloseHooks(libkb.ConnectionID(42))
+
+	if gotConnID != libkb.ConnectionID(42) {
+		t.Fatalf("expected connID 42, got %d", gotConnID)
+	}
+}
+
+func TestUseMiddlewareIsConcurrencySafe(t *testing.T) {
+	d := &Service{}
+	noop := Middleware(func(ctx context.Context, call MiddlewareCall, next func(context.Context) (interface{}, error)) (interface{}, error) {
+		return next(ctx)
+	})
+
+	const n = 50
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			d.UseMiddleware(noop)
+		}()
+	}
+	wg.Wait()
+
+	if len(d.middlewares) != n {
+		t.Errorf("expected %d middlewares installed, got %d", n, len(d.middlewares))
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/client/go/libkb"
+)
+
+// MiddlewareCall carries the per-invocation details a Middleware might want:
+// which connection and fully-qualified method ("Protocol.method") this is.
+type MiddlewareCall struct {
+	ConnID libkb.ConnectionID
+	Method string
+}
+
+// Middleware wraps a single RPC method invocation. next invokes the next
+// middleware in the chain (or the real handler) and returns its result;
+// a Middleware is free to inspect/replace the result, decline to call next
+// at all, or recover from a panic next causes.
+type Middleware func(ctx context.Context, call MiddlewareCall, next func(context.Context) (interface{}, error)) (interface{}, error)
+
+// LoggingMiddleware logs a structured line for every call: method, connID,
+// the service's logged-in UID (this daemon serves a single local user), and
+// duration.
+func LoggingMiddleware(g *libkb.GlobalContext) Middleware {
+	return func(ctx context.Context, call MiddlewareCall, next func(context.Context) (interface{}, error)) (interface{}, error) {
+		start := time.Now()
+		res, err := next(ctx)
+		g.Log.Debug("rpc: method=%s connID=%d uid=%s duration=%s err=%v",
+			call.Method, call.ConnID, g.Env.GetUID(), time.Since(start), err)
+		return res, err
+	}
+}
+
+// tokenBucket is a minimal, mutex-protected token bucket: it refills at
+// rate tokens/sec up to burst capacity, and Allow reports whether a token
+// was available to spend.
+type tokenBucket struct {
+	mu       sync.Mutex
+	tokens   float64
+	capacity float64
+	rate     float64
+	last     time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		tokens:   float64(burst),
+		capacity: float64(burst),
+		rate:     rate,
+		last:     time.Now(),
+	}
+}
+
+func (t *tokenBucket) Allow() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.tokens += t.rate * now.Sub(t.last).Seconds()
+	if t.tokens > t.capacity {
+		t.tokens = t.capacity
+	}
+	t.last = now
+	if t.tokens < 1 {
+		return false
+	}
+	t.tokens--
+	return true
+}
+
+// RateLimitMiddleware enforces a per-connection and a per-method token
+// bucket, protecting against a runaway caller (e.g. an Electron renderer
+// gone wild) flooding a single method or connection with calls. It
+// registers an OnConnClose hook on d to evict a connection's bucket from
+// perConn once it closes, so perConn doesn't grow without bound over the
+// lifetime of a long-running service.
+func RateLimitMiddleware(d *Service, ratePerSec float64, burst int) Middleware {
+	var mu sync.Mutex
+	perConn := make(map[libkb.ConnectionID]*tokenBucket)
+	perMethod := make(map[string]*tokenBucket)
+
+	bucketFor := func(buckets map[string]*tokenBucket, key string) *tokenBucket {
+		mu.Lock()
+		defer mu.Unlock()
+		b, ok := buckets[key]
+		if !ok {
+			b = newTokenBucket(ratePerSec, burst)
+			buckets[key] = b
+		}
+		return b
+	}
+	connBucketFor := func(connID libkb.ConnectionID) *tokenBucket {
+		mu.Lock()
+		defer mu.Unlock()
+		b, ok := perConn[connID]
+		if !ok {
+			b = newTokenBucket(ratePerSec, burst)
+			perConn[connID] = b
+		}
+		return b
+	}
+	d.OnConnClose(func(connID libkb.ConnectionID) {
+		mu.Lock()
+		delete(perConn, connID)
+		mu.Unlock()
+	})
+
+	return func(ctx context.Context, call MiddlewareCall, next func(context.Context) (interface{}, error)) (interface{}, error) {
+		if !connBucketFor(call.ConnID).Allow() || !bucketFor(perMethod, call.Method).Allow() {
+			d.G().Log.Warning("rpc: rate limit exceeded for method=%s connID=%d", call.Method, call.ConnID)
+			return nil, fmt.Errorf("rate limit exceeded for %s", call.Method)
+		}
+		return next(ctx)
+	}
+}
+
+// panicCount is incremented by RecoveryMiddleware every time it catches a
+// panic, so operators can alert on it without parsing logs.
+var panicCount int64
+
+// RecoveryMiddleware converts a panic in any downstream middleware or
+// handler into an error naming the method and bumps panicCount, instead of
+// tearing down the whole connection. The error is a plain fmt.Errorf value;
+// there is no dedicated error type for a recovered panic.
+func RecoveryMiddleware(g *libkb.GlobalContext) Middleware {
+	return func(ctx context.Context, call MiddlewareCall, next func(context.Context) (interface{}, error)) (res interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				atomic.AddInt64(&panicCount, 1)
+				g.Log.Errorf("rpc: recovered panic in method=%s connID=%d: %v", call.Method, call.ConnID, r)
+				err = fmt.Errorf("internal error handling %s", call.Method)
+			}
+		}()
+		return next(ctx)
+	}
+}
+
+// PanicCount reports how many panics RecoveryMiddleware has caught so far.
+func PanicCount() int64 {
+	return atomic.LoadInt64(&panicCount)
+}
+
+// privilegedProtocols names the RPC protocols whose methods touch secret
+// key material or other state that must stay confined to this process's
+// own uid: another local user (or, on a multi-user box, another session)
+// attaching to the socket must not be able to reach them.
+var privilegedProtocols = map[string]bool{
+	"secretkeys":     true,
+	"revoke":         true,
+	"pgp":            true,
+	"paperprovision": true,
+	"signup":         true,
+}
+
+// isPrivilegedMethod reports whether method (formatted "Protocol.Method")
+// belongs to a protocol in privilegedProtocols.
+func isPrivilegedMethod(method string) bool {
+	proto := method
+	if i := strings.IndexByte(method, '.'); i >= 0 {
+		proto = method[:i]
+	}
+	return privilegedProtocols[proto]
+}
+
+// AuthRequiredMiddleware rejects calls to any method isPrivileged reports
+// true for unless the calling connection's unix socket peer uid matches
+// the uid this process is running as, defending against another local
+// user connecting to the socket. Connections whose peer uid couldn't be
+// determined are rejected too, not allowed through: on platforms
+// peerUID doesn't support (see peercred_other.go, which is every non-Linux
+// platform today, including the macOS and Windows the Electron GUI runs
+// on), lookupPeerUID never succeeds, and failing open there would make
+// this middleware a no-op exactly where it matters most.
+func AuthRequiredMiddleware(d *Service, isPrivileged func(method string) bool) Middleware {
+	selfUID := os.Getuid()
+	return func(ctx context.Context, call MiddlewareCall, next func(context.Context) (interface{}, error)) (interface{}, error) {
+		if !isPrivileged(call.Method) {
+			return next(ctx)
+		}
+		uid, ok := d.lookupPeerUID(call.ConnID)
+		if !ok || uid != selfUID {
+			return nil, fmt.Errorf("method %s requires same-uid access (peer uid %d, expected %d, known=%v)",
+				call.Method, uid, selfUID, ok)
+		}
+		return next(ctx)
+	}
+}
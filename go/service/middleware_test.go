@@ -0,0 +1,54 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketAllowsUpToBurstThenBlocks(t *testing.T) {
+	tb := newTokenBucket(1, 3)
+	for i := 0; i < 3; i++ {
+		if !tb.Allow() {
+			t.Fatalf("expected token %d to be allowed within burst", i)
+		}
+	}
+	if tb.Allow() {
+		t.Fatal("expected bucket to be exhausted after burst tokens spent")
+	}
+}
+
+func TestTokenBucketRefillsOverTime(t *testing.T) {
+	tb := newTokenBucket(1000, 1)
+	if !tb.Allow() {
+		t.Fatal("expected the initial token to be allowed")
+	}
+	if tb.Allow() {
+		t.Fatal("expected the bucket to be empty immediately after spending its only token")
+	}
+	tb.last = tb.last.Add(-time.Second)
+	if !tb.Allow() {
+		t.Fatal("expected a high refill rate to have replenished a token after a second")
+	}
+}
+
+func TestIsPrivilegedMethod(t *testing.T) {
+	cases := []struct {
+		method string
+		want   bool
+	}{
+		{"secretkeys.getSecretKeys", true},
+		{"revoke.revokeDevice", true},
+		{"pgp.pgpSign", true},
+		{"config.getConfig", false},
+		{"login.login", false},
+		{"malformed-method-no-dot", false},
+	}
+	for _, c := range cases {
+		if got := isPrivilegedMethod(c.method); got != c.want {
+			t.Errorf("isPrivilegedMethod(%q) = %v, want %v", c.method, got, c.want)
+		}
+	}
+}
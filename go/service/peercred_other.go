@@ -0,0 +1,18 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+// +build !linux
+
+package service
+
+import (
+	"errors"
+	"net"
+)
+
+// peerUID is not yet implemented outside Linux; AuthRequiredMiddleware
+// treats that as an unknown peer uid and rejects privileged calls rather
+// than allowing them through.
+func peerUID(c net.Conn) (int, error) {
+	return 0, errors.New("peer credential lookup not supported on this platform")
+}
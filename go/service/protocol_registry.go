@@ -0,0 +1,192 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package service
+
+import (
+	"sync"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/chat1"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/go-framed-msgpack-rpc/rpc"
+)
+
+// ProtocolFactory builds one connection's instance of a protocol. logReg is
+// only meaningful to the "log" protocol; everything else ignores it.
+type ProtocolFactory func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error)
+
+// ProtocolRegistry holds the named ProtocolFactorys a connection's
+// RegisterProtocols instantiates from. It starts out populated by
+// registerBuiltinProtocols, but RegisterProtocolFactory/UnregisterProtocolFactory
+// let mobile builds strip protocols, test harnesses inject fakes, and
+// out-of-tree code (a vendor's KBFS extension, a future plugin loader) add
+// their own without touching this file.
+type ProtocolRegistry struct {
+	mu        sync.RWMutex
+	factories map[string]ProtocolFactory
+}
+
+func newProtocolRegistry() *ProtocolRegistry {
+	return &ProtocolRegistry{factories: make(map[string]ProtocolFactory)}
+}
+
+// Register adds (or replaces) the factory for name.
+func (r *ProtocolRegistry) Register(name string, factory ProtocolFactory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.factories[name] = factory
+}
+
+// Unregister removes the factory for name, if any.
+func (r *ProtocolRegistry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.factories, name)
+}
+
+// Build instantiates every registered factory not named in disabled,
+// returning their protocols and, for the ones that need connection
+// teardown, their Shutdowners.
+func (r *ProtocolRegistry) Build(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister,
+	g *libkb.GlobalContext, disabled map[string]bool) (protocols []rpc.Protocol, shutdowners []Shutdowner, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for name, factory := range r.factories {
+		if disabled[name] {
+			continue
+		}
+		proto, shutdowner, ferr := factory(xp, connID, logReg, g)
+		if ferr != nil {
+			return nil, nil, ferr
+		}
+		protocols = append(protocols, proto)
+		if shutdowner != nil {
+			shutdowners = append(shutdowners, shutdowner)
+		}
+	}
+	return protocols, shutdowners, nil
+}
+
+// registerBuiltinProtocols populates d.protocols with every protocol this
+// service has always registered. It closes over d so factories can reach
+// d.rekeyMaster, d.gregor, and d.attachmentstore the same way the old
+// hard-coded RegisterProtocols body did.
+func (d *Service) registerBuiltinProtocols() {
+	r := d.protocols
+	r.Register("account", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.AccountProtocol(NewAccountHandler(xp, g)), nil, nil
+	})
+	r.Register("btc", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.BTCProtocol(NewCryptocurrencyHandler(xp, g)), nil, nil
+	})
+	r.Register("cryptocurrency", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.CryptocurrencyProtocol(NewCryptocurrencyHandler(xp, g)), nil, nil
+	})
+	r.Register("config", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.ConfigProtocol(NewConfigHandler(xp, connID, g, d)), nil, nil
+	})
+	r.Register("crypto", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.CryptoProtocol(NewCryptoHandler(g)), nil, nil
+	})
+	r.Register("ctl", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.CtlProtocol(NewCtlHandler(xp, d, g)), nil, nil
+	})
+	r.Register("debugging", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.DebuggingProtocol(NewDebuggingHandler(xp)), nil, nil
+	})
+	r.Register("delegateuictl", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.DelegateUiCtlProtocol(NewDelegateUICtlHandler(xp, connID, g, d.rekeyMaster)), nil, nil
+	})
+	r.Register("device", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.DeviceProtocol(NewDeviceHandler(xp, g)), nil, nil
+	})
+	r.Register("favorite", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.FavoriteProtocol(NewFavoriteHandler(xp, g)), nil, nil
+	})
+	r.Register("tlf", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.TlfProtocol(newTlfHandler(xp, g)), nil, nil
+	})
+	r.Register("identify", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.IdentifyProtocol(NewIdentifyHandler(xp, g)), nil, nil
+	})
+	r.Register("kbfs", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.KbfsProtocol(NewKBFSHandler(xp, g)), nil, nil
+	})
+	r.Register("kbfsmount", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.KbfsMountProtocol(NewKBFSMountHandler(xp, g)), nil, nil
+	})
+	r.Register("log", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.LogProtocol(NewLogHandler(xp, logReg, g)), nil, nil
+	})
+	r.Register("login", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.LoginProtocol(NewLoginHandler(xp, g)), nil, nil
+	})
+	r.Register("notifyctl", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.NotifyCtlProtocol(NewNotifyCtlHandler(xp, connID, g)), nil, nil
+	})
+	r.Register("pgp", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.PGPProtocol(NewPGPHandler(xp, g)), nil, nil
+	})
+	r.Register("reachability", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.ReachabilityProtocol(newReachabilityHandler(xp, g, d.reachability)), nil, nil
+	})
+	r.Register("revoke", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.RevokeProtocol(NewRevokeHandler(xp, g)), nil, nil
+	})
+	r.Register("prove", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.ProveProtocol(NewProveHandler(xp, g)), nil, nil
+	})
+	r.Register("saltpack", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.SaltpackProtocol(NewSaltpackHandler(xp, g)), nil, nil
+	})
+	r.Register("scanproofs", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.ScanProofsProtocol(NewScanProofsHandler(xp, g)), nil, nil
+	})
+	r.Register("secretkeys", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.SecretKeysProtocol(NewSecretKeysHandler(xp, g)), nil, nil
+	})
+	r.Register("session", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.SessionProtocol(NewSessionHandler(xp, g)), nil, nil
+	})
+	r.Register("signup", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.SignupProtocol(NewSignupHandler(xp, g)), nil, nil
+	})
+	r.Register("sigs", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.SigsProtocol(NewSigsHandler(xp, g)), nil, nil
+	})
+	r.Register("test", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.TestProtocol(NewTestHandler(xp, g)), nil, nil
+	})
+	r.Register("track", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.TrackProtocol(NewTrackHandler(xp, g)), nil, nil
+	})
+	r.Register("user", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.UserProtocol(NewUserHandler(xp, g)), nil, nil
+	})
+	r.Register("apiserver", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.ApiserverProtocol(NewAPIServerHandler(xp, g)), nil, nil
+	})
+	r.Register("paperprovision", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.PaperprovisionProtocol(NewPaperProvisionHandler(xp, g)), nil, nil
+	})
+	r.Register("rekey", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.RekeyProtocol(NewRekeyHandler2(xp, g, d.rekeyMaster)), nil, nil
+	})
+	r.Register("notifyfsrequest", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.NotifyFSRequestProtocol(newNotifyFSRequestHandler(xp, g)), nil, nil
+	})
+	r.Register("gregor", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.GregorProtocol(newGregorRPCHandler(xp, g, d.gregor)), nil, nil
+	})
+	r.Register("chat", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return chat1.LocalProtocol(newChatLocalHandler(xp, g, d.attachmentstore, d.gregor)), nil, nil
+	})
+	r.Register("simplefs", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.SimpleFSProtocol(NewSimpleFSHandler(xp, g)), nil, nil
+	})
+	r.Register("logsend", func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return keybase1.LogsendProtocol(NewLogsendHandler(xp, g)), nil, nil
+	})
+}
@@ -0,0 +1,59 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package service
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/go-framed-msgpack-rpc/rpc"
+)
+
+func stubFactory(name string) ProtocolFactory {
+	return func(xp rpc.Transporter, connID libkb.ConnectionID, logReg *logRegister, g *libkb.GlobalContext) (rpc.Protocol, Shutdowner, error) {
+		return rpc.Protocol{Name: name}, nil, nil
+	}
+}
+
+func TestProtocolRegistryBuildSkipsDisabled(t *testing.T) {
+	r := newProtocolRegistry()
+	r.Register("account", stubFactory("account"))
+	r.Register("chat", stubFactory("chat"))
+
+	protocols, _, err := r.Build(nil, libkb.ConnectionID(0), nil, nil, map[string]bool{"chat": true})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(protocols) != 1 || protocols[0].Name != "account" {
+		t.Fatalf("expected only the non-disabled protocol to be built, got %v", protocols)
+	}
+}
+
+func TestProtocolRegistryUnregisterRemovesFactory(t *testing.T) {
+	r := newProtocolRegistry()
+	r.Register("account", stubFactory("account"))
+	r.Unregister("account")
+
+	protocols, _, err := r.Build(nil, libkb.ConnectionID(0), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(protocols) != 0 {
+		t.Fatalf("expected no protocols after Unregister, got %v", protocols)
+	}
+}
+
+func TestProtocolRegistryRegisterReplacesExisting(t *testing.T) {
+	r := newProtocolRegistry()
+	r.Register("account", stubFactory("account-v1"))
+	r.Register("account", stubFactory("account-v2"))
+
+	protocols, _, err := r.Build(nil, libkb.ConnectionID(0), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(protocols) != 1 || protocols[0].Name != "account-v2" {
+		t.Fatalf("expected the later Register to replace the earlier one, got %v", protocols)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package service
+
+import (
+	"sync"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+// reachability tracks this process's best guess at network reachability,
+// as reported out-of-band through the "reachability" RPC protocol (the
+// Electron renderer's navigator.onLine, mobile's connectivity manager),
+// and lets other subsystems register to hear about every change instead
+// of polling it.
+type reachability struct {
+	libkb.Contextified
+
+	mu        sync.Mutex
+	state     keybase1.Reachable
+	listeners []func(keybase1.Reachable)
+}
+
+func newReachability(g *libkb.GlobalContext) *reachability {
+	return &reachability{
+		Contextified: libkb.NewContextified(g),
+	}
+}
+
+// OnChange registers fn to be called, with the new state, every time
+// SetReachability reports a change. gregorSupervisor uses this to skip
+// the rest of its current backoff the moment the network comes back,
+// instead of waiting it out.
+func (r *reachability) OnChange(fn func(keybase1.Reachable)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners = append(r.listeners, fn)
+}
+
+// SetReachability records the latest state reported over RPC and, if it
+// actually changed, calls every registered listener with the new value.
+func (r *reachability) SetReachability(state keybase1.Reachable) {
+	r.mu.Lock()
+	if r.state == state {
+		r.mu.Unlock()
+		return
+	}
+	r.state = state
+	listeners := append([]func(keybase1.Reachable){}, r.listeners...)
+	r.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(state)
+	}
+}
+
+// Reachability reports the last state SetReachability recorded.
+func (r *reachability) Reachability() keybase1.Reachable {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
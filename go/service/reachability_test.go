@@ -0,0 +1,37 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package service
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+)
+
+func TestReachabilityNotifiesListenersOnChange(t *testing.T) {
+	r := &reachability{}
+
+	var got []keybase1.Reachable
+	r.OnChange(func(state keybase1.Reachable) {
+		got = append(got, state)
+	})
+
+	r.SetReachability(keybase1.Reachable_YES)
+	r.SetReachability(keybase1.Reachable_YES) // no-op, state unchanged
+	r.SetReachability(keybase1.Reachable_NO)
+
+	want := []keybase1.Reachable{keybase1.Reachable_YES, keybase1.Reachable_NO}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+
+	if r.Reachability() != keybase1.Reachable_NO {
+		t.Fatalf("expected Reachability() to report the last set state")
+	}
+}
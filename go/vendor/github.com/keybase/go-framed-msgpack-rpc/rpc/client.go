@@ -2,24 +2,100 @@ package rpc
 
 import (
 	"errors"
+	"sync"
 
 	"golang.org/x/net/context"
 )
 
+// CallHandler is the terminal (or next-in-chain) function a CallInterceptor
+// invokes to continue a Call. The built-in dispatcher call is wrapped as a
+// CallHandler so interceptors can't tell the difference between calling
+// through to the wire and calling the next interceptor.
+type CallHandler func(ctx context.Context, method string, arg interface{}, res interface{}) error
+
+// CallInterceptor wraps a Call, optionally inspecting or mutating the
+// context/arg, deciding whether to invoke next at all, and inspecting or
+// replacing the resulting error. Interceptors compose right-to-left: the
+// last interceptor in the slice is the innermost, closest to the wire.
+type CallInterceptor func(ctx context.Context, method string, arg interface{}, res interface{}, next CallHandler) error
+
+// NotifyHandler is the terminal (or next-in-chain) function a
+// NotifyInterceptor invokes to continue a Notify.
+type NotifyHandler func(ctx context.Context, method string, arg interface{}) error
+
+// NotifyInterceptor wraps a Notify the same way CallInterceptor wraps a
+// Call.
+type NotifyInterceptor func(ctx context.Context, method string, arg interface{}, next NotifyHandler) error
+
 // Client allows calls and notifies on the given transporter, or any protocol
 // type. All will share the same ErrorUnwrapper hook for unwrapping incoming
 // msgpack objects and converting to possible Go-native `Error` types
 type Client struct {
 	xp             Transporter
 	errorUnwrapper ErrorUnwrapper
-	tagsFunc       LogTagsFromContext
+
+	mu       sync.RWMutex
+	calls    []CallInterceptor
+	notifies []NotifyInterceptor
 }
 
 // NewClient constructs a new client from the given RPC Transporter and the
-// ErrorUnwrapper.
+// ErrorUnwrapper. If tagsFunc is non-nil, the returned Client installs it as
+// a built-in CallInterceptor that copies context values into CtxRpcTags
+// before every Call, exactly as it did before interceptors existed.
 func NewClient(xp Transporter, u ErrorUnwrapper,
 	tagsFunc LogTagsFromContext) *Client {
-	return &Client{xp, u, tagsFunc}
+	return NewClientWithInterceptors(xp, u, tagsFunc, nil, nil)
+}
+
+// NewClientWithInterceptors constructs a new client with an explicit set of
+// CallInterceptors and NotifyInterceptors installed up front. If tagsFunc is
+// non-nil, it is wired in as an additional CallInterceptor (ahead of the ones
+// in calls) so the built-in tags behavior stays available to callers who
+// don't want to reach for TagsCallInterceptor themselves; pass nil if the
+// calls slice already handles tagging (or tagging isn't wanted).
+func NewClientWithInterceptors(xp Transporter, u ErrorUnwrapper,
+	tagsFunc LogTagsFromContext, calls []CallInterceptor, notifies []NotifyInterceptor) *Client {
+	if tagsFunc != nil {
+		calls = append([]CallInterceptor{TagsCallInterceptor(tagsFunc)}, calls...)
+	}
+	return &Client{
+		xp:             xp,
+		errorUnwrapper: u,
+		calls:          calls,
+		notifies:       notifies,
+	}
+}
+
+// Use appends CallInterceptors to the chain that future Call invocations
+// will run through. Interceptors added later run closer to the wire than
+// ones added earlier. Safe to call concurrently with Call, but interceptors
+// added while a Call is already building its chain only apply to later
+// calls.
+func (c *Client) Use(interceptors ...CallInterceptor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, interceptors...)
+}
+
+// UseNotify appends NotifyInterceptors to the chain that future Notify
+// invocations will run through, with the same ordering and concurrency
+// rules as Use.
+func (c *Client) UseNotify(interceptors ...NotifyInterceptor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.notifies = append(c.notifies, interceptors...)
+}
+
+// dispatchCall is the innermost CallHandler: it does the actual work Call
+// used to do unconditionally before interceptors existed.
+func (c *Client) dispatchCall(ctx context.Context, method string, arg interface{}, res interface{}) error {
+	c.xp.receiveFrames()
+	d, err := c.xp.getDispatcher()
+	if err != nil {
+		return err
+	}
+	return d.Call(ctx, method, arg, res, c.errorUnwrapper)
 }
 
 // Call makes an msgpack RPC call over the transports that's bound to this
@@ -27,45 +103,86 @@ func NewClient(xp Transporter, u ErrorUnwrapper,
 // the result field will be populated (if applicable). It returns an Error
 // on error, where the error might have been unwrapped from Msgpack via the
 // UnwrapErrorFunc in this client.
+//
+// If any CallInterceptors were installed via NewClientWithInterceptors or
+// Use, they run around the dispatch in the order given, with the last one
+// in the slice wrapping the dispatch most tightly.
 func (c *Client) Call(ctx context.Context, method string, arg interface{}, res interface{}) (err error) {
 	if ctx == nil {
 		return errors.New("No Context provided for this call")
 	}
 
-	if c.tagsFunc != nil {
-		tags, ok := c.tagsFunc(ctx)
-		if ok {
-			rpcTags := make(CtxRpcTags)
-			for key, tagName := range tags {
-				if v := ctx.Value(key); v != nil {
-					rpcTags[tagName] = v
-				}
-			}
-			ctx = AddRpcTagsToContext(ctx, rpcTags)
+	c.mu.RLock()
+	calls := c.calls
+	c.mu.RUnlock()
+
+	handler := CallHandler(c.dispatchCall)
+	for i := len(calls) - 1; i >= 0; i-- {
+		interceptor := calls[i]
+		next := handler
+		handler = func(ctx context.Context, method string, arg interface{}, res interface{}) error {
+			return interceptor(ctx, method, arg, res, next)
 		}
 	}
+	return handler(ctx, method, arg, res)
+}
 
-	c.xp.receiveFrames()
+// dispatchNotify is the innermost NotifyHandler.
+func (c *Client) dispatchNotify(ctx context.Context, method string, arg interface{}) error {
 	d, err := c.xp.getDispatcher()
 	if err != nil {
 		return err
 	}
-	return d.Call(ctx, method, arg, res, c.errorUnwrapper)
+	return d.Notify(ctx, method, arg)
 }
 
 // Notify notifies the server, with the given method and argument. It does not
 // wait to hear back for an error. An error might happen in sending the call, in
 // which case a native Go Error is returned. The UnwrapErrorFunc in the underlying
 // client isn't relevant in this case.
+//
+// NotifyInterceptors installed via UseNotify run the same way CallInterceptors
+// do for Call.
 func (c *Client) Notify(ctx context.Context, method string, arg interface{}) (err error) {
 	if ctx == nil {
 		return errors.New("No Context provided for this notification")
 	}
-	d, err := c.xp.getDispatcher()
-	if err != nil {
-		return err
+
+	c.mu.RLock()
+	notifies := c.notifies
+	c.mu.RUnlock()
+
+	handler := NotifyHandler(c.dispatchNotify)
+	for i := len(notifies) - 1; i >= 0; i-- {
+		interceptor := notifies[i]
+		next := handler
+		handler = func(ctx context.Context, method string, arg interface{}) error {
+			return interceptor(ctx, method, arg, next)
+		}
+	}
+	return handler(ctx, method, arg)
+}
+
+// TagsCallInterceptor reproduces the log-tags-from-context behavior that
+// used to be hard-coded into Call: it reads the tags tagsFunc reports for
+// the context, copies the ones present on ctx into a CtxRpcTags, and adds
+// that to the context before continuing the chain.
+func TagsCallInterceptor(tagsFunc LogTagsFromContext) CallInterceptor {
+	return func(ctx context.Context, method string, arg interface{}, res interface{}, next CallHandler) error {
+		if tagsFunc != nil {
+			tags, ok := tagsFunc(ctx)
+			if ok {
+				rpcTags := make(CtxRpcTags)
+				for key, tagName := range tags {
+					if v := ctx.Value(key); v != nil {
+						rpcTags[tagName] = v
+					}
+				}
+				ctx = AddRpcTagsToContext(ctx, rpcTags)
+			}
+		}
+		return next(ctx, method, arg, res)
 	}
-	return d.Notify(ctx, method, arg)
 }
 
 // GenericClient is the interface that is exported to autogenerated RPC stubs
@@ -0,0 +1,102 @@
+package rpc
+
+import (
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestTagsCallInterceptorAddsTagsWhenPresent(t *testing.T) {
+	type ctxKey string
+	const key ctxKey = "reqID"
+
+	tagsFunc := func(ctx context.Context) (map[interface{}]string, bool) {
+		return map[interface{}]string{key: "RequestID"}, true
+	}
+
+	ctx := context.WithValue(context.Background(), key, "abc123")
+	var gotCtx context.Context
+	interceptor := TagsCallInterceptor(tagsFunc)
+	err := interceptor(ctx, "Some.method", nil, nil, func(ctx context.Context, method string, arg interface{}, res interface{}) error {
+		gotCtx = ctx
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if gotCtx == ctx {
+		t.Fatal("expected AddRpcTagsToContext to return a derived context carrying the tags, not the original")
+	}
+}
+
+func TestTagsCallInterceptorNoopsWhenTagsAbsent(t *testing.T) {
+	tagsFunc := func(ctx context.Context) (map[interface{}]string, bool) {
+		return nil, false
+	}
+
+	ctx := context.Background()
+	var gotCtx context.Context
+	interceptor := TagsCallInterceptor(tagsFunc)
+	err := interceptor(ctx, "Some.method", nil, nil, func(ctx context.Context, method string, arg interface{}, res interface{}) error {
+		gotCtx = ctx
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if gotCtx != ctx {
+		t.Fatal("expected the context to pass through unchanged when tagsFunc reports no tags")
+	}
+}
+
+func TestNewClientWithInterceptorsWiresTagsFunc(t *testing.T) {
+	tagsFunc := func(ctx context.Context) (map[interface{}]string, bool) {
+		return nil, false
+	}
+	explicit := func(ctx context.Context, method string, arg interface{}, res interface{}, next CallHandler) error {
+		return next(ctx, method, arg, res)
+	}
+
+	c := NewClientWithInterceptors(nil, nil, tagsFunc, []CallInterceptor{explicit}, nil)
+	if len(c.calls) != 2 {
+		t.Fatalf("expected tagsFunc to be wired in as an additional CallInterceptor, got %d calls", len(c.calls))
+	}
+
+	c = NewClientWithInterceptors(nil, nil, nil, []CallInterceptor{explicit}, nil)
+	if len(c.calls) != 1 {
+		t.Fatalf("expected a nil tagsFunc to install nothing extra, got %d calls", len(c.calls))
+	}
+}
+
+func TestClientUseAndUseNotifyAreConcurrencySafe(t *testing.T) {
+	c := &Client{}
+	noop := func(ctx context.Context, method string, arg interface{}, res interface{}, next CallHandler) error {
+		return next(ctx, method, arg, res)
+	}
+	noopNotify := func(ctx context.Context, method string, arg interface{}, next NotifyHandler) error {
+		return next(ctx, method, arg)
+	}
+
+	const n = 50
+	var wg sync.WaitGroup
+	wg.Add(2 * n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			c.Use(noop)
+		}()
+		go func() {
+			defer wg.Done()
+			c.UseNotify(noopNotify)
+		}()
+	}
+	wg.Wait()
+
+	if len(c.calls) != n {
+		t.Errorf("expected %d call interceptors installed, got %d", n, len(c.calls))
+	}
+	if len(c.notifies) != n {
+		t.Errorf("expected %d notify interceptors installed, got %d", n, len(c.notifies))
+	}
+}
@@ -0,0 +1,102 @@
+package rpc
+
+import (
+	"log"
+	"math/rand"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// RetryableErrorClassifier decides whether an error returned from a Call is
+// worth retrying (e.g. a transient network error surfaced through
+// ErrorUnwrapper) as opposed to a permanent one (bad arguments, auth
+// failure) that would just fail again.
+type RetryableErrorClassifier func(err error) bool
+
+// BackoffRetryInterceptor retries a Call up to maxRetries times on errors
+// that classify as retryable, sleeping for an exponentially increasing,
+// jittered delay between attempts starting at baseDelay and capped at
+// maxDelay. It gives up and returns the last error once maxRetries is
+// exhausted or as soon as classify reports an error isn't retryable; if the
+// context is done while it waits to retry, it returns ctx.Err() instead.
+func BackoffRetryInterceptor(maxRetries int, baseDelay, maxDelay time.Duration, classify RetryableErrorClassifier) CallInterceptor {
+	return func(ctx context.Context, method string, arg interface{}, res interface{}, next CallHandler) error {
+		delay := baseDelay
+		var err error
+		for attempt := 0; attempt <= maxRetries; attempt++ {
+			err = next(ctx, method, arg, res)
+			if err == nil || !classify(err) {
+				return err
+			}
+			if attempt == maxRetries {
+				break
+			}
+			jittered := time.Duration(float64(delay) * (0.5 + rand.Float64()))
+			select {
+			case <-time.After(jittered):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			delay *= 2
+			if delay > maxDelay {
+				delay = maxDelay
+			}
+		}
+		return err
+	}
+}
+
+// DeadlineInterceptor injects a per-call deadline onto the context, unless
+// the caller already set one that expires sooner.
+func DeadlineInterceptor(timeout time.Duration) CallInterceptor {
+	return func(ctx context.Context, method string, arg interface{}, res interface{}, next CallHandler) error {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+			return next(ctx, method, arg, res)
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return next(ctx, method, arg, res)
+	}
+}
+
+// MetricsRecorder is the subset of a Prometheus-style metrics client this
+// package depends on, so callers can plug in a real *prometheus.HistogramVec
+// (or any other compatible recorder) without this package vendoring the
+// prometheus client itself.
+type MetricsRecorder interface {
+	ObserveCall(method string, duration time.Duration, err error)
+}
+
+// MetricsInterceptor records call latency and outcome, tagged by method
+// name, through the given MetricsRecorder.
+func MetricsInterceptor(recorder MetricsRecorder) CallInterceptor {
+	return func(ctx context.Context, method string, arg interface{}, res interface{}, next CallHandler) error {
+		start := time.Now()
+		err := next(ctx, method, arg, res)
+		recorder.ObserveCall(method, time.Since(start), err)
+		return err
+	}
+}
+
+// DebugTracer is the logging sink TraceInterceptor writes to; *log.Logger
+// satisfies it directly.
+type DebugTracer interface {
+	Printf(format string, args ...interface{})
+}
+
+// TraceInterceptor logs the start, end, duration, and outcome of every
+// call it wraps. It's meant for ad-hoc debugging, not production use, since
+// it logs every method unconditionally.
+func TraceInterceptor(tracer DebugTracer) CallInterceptor {
+	if tracer == nil {
+		tracer = log.New(log.Writer(), "rpc: ", log.LstdFlags)
+	}
+	return func(ctx context.Context, method string, arg interface{}, res interface{}, next CallHandler) error {
+		start := time.Now()
+		tracer.Printf("-> %s", method)
+		err := next(ctx, method, arg, res)
+		tracer.Printf("<- %s (%s) err=%v", method, time.Since(start), err)
+		return err
+	}
+}
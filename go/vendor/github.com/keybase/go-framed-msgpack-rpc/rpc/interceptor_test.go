@@ -0,0 +1,72 @@
+package rpc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestBackoffRetryInterceptorStopsOnNonRetryableError(t *testing.T) {
+	permanent := errors.New("bad arguments")
+	attempts := 0
+	interceptor := BackoffRetryInterceptor(5, time.Millisecond, time.Millisecond, func(err error) bool {
+		return false
+	})
+	err := interceptor(context.Background(), "Some.method", nil, nil, func(ctx context.Context, method string, arg interface{}, res interface{}) error {
+		attempts++
+		return permanent
+	})
+	if err != permanent {
+		t.Fatalf("expected the permanent error to be returned unchanged, got %v", err)
+	}
+	if attempts != 1 {
+		t.Fatalf("expected a non-retryable error to stop after one attempt, got %d", attempts)
+	}
+}
+
+func TestBackoffRetryInterceptorRetriesUpToMax(t *testing.T) {
+	transient := errors.New("transient")
+	attempts := 0
+	interceptor := BackoffRetryInterceptor(3, time.Millisecond, time.Millisecond, func(err error) bool {
+		return true
+	})
+	err := interceptor(context.Background(), "Some.method", nil, nil, func(ctx context.Context, method string, arg interface{}, res interface{}) error {
+		attempts++
+		return transient
+	})
+	if err != transient {
+		t.Fatalf("expected the last error to be returned once retries are exhausted, got %v", err)
+	}
+	if attempts != 4 {
+		t.Fatalf("expected maxRetries=3 to mean 4 total attempts, got %d", attempts)
+	}
+}
+
+func TestMetricsInterceptorRecordsOutcome(t *testing.T) {
+	var gotMethod string
+	var gotErr error
+	recorder := recorderFunc(func(method string, duration time.Duration, err error) {
+		gotMethod = method
+		gotErr = err
+	})
+	failure := errors.New("boom")
+	interceptor := MetricsInterceptor(recorder)
+	err := interceptor(context.Background(), "Some.method", nil, nil, func(ctx context.Context, method string, arg interface{}, res interface{}) error {
+		return failure
+	})
+	if err != failure {
+		t.Fatalf("expected the interceptor to pass the error through, got %v", err)
+	}
+	if gotMethod != "Some.method" || gotErr != failure {
+		t.Fatalf("expected the recorder to observe method=%q err=%v, got method=%q err=%v",
+			"Some.method", failure, gotMethod, gotErr)
+	}
+}
+
+type recorderFunc func(method string, duration time.Duration, err error)
+
+func (f recorderFunc) ObserveCall(method string, duration time.Duration, err error) {
+	f(method, duration, err)
+}